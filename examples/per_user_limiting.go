@@ -37,8 +37,11 @@ func main() {
 		key := fmt.Sprintf("user_rate_limit:%s", userID)
 		res, err := limiter.Allow(r.Context(), key)
 		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+			log.Printf("Rate limiter error: %v", err)
+			if res == nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
 		}
 
 		if !res.Allowed {
@@ -0,0 +1,247 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateSweepSize is the number of cached rates above which stale ones are dropped
+const rateSweepSize = 1024
+
+// Outcome is what a caller observed after using a permit, reported to an Adaptive limiter
+type Outcome int
+
+const (
+	// OutcomeSuccess means the request succeeded within the latency target.
+	OutcomeSuccess Outcome = iota
+	// OutcomeThrottled means the upstream rejected the request, e.g. with a 429.
+	OutcomeThrottled
+	// OutcomeSlow means the request succeeded but breached the latency target.
+	OutcomeSlow
+)
+
+// FeedbackReceiver is implemented by limiters that learn from the outcome of requests, such as Adaptive.
+type FeedbackReceiver interface {
+	// Feedback reports the outcome of a request made for the given key.
+	Feedback(ctx context.Context, key string, outcome Outcome) error
+}
+
+// Adaptive is a Limiter that learns the rate per key with AIMD (additive
+// increase, multiplicative decrease). Every success raises the rate by a fixed
+// step; throttling or a latency breach multiplies it by a factor below one.
+//
+// The learned rate of each key is stored in Redis so that all instances share
+// it. Instances cache it locally for a short refresh interval to avoid an
+// extra round trip on every Allow call.
+type Adaptive struct {
+	lb       *LeakyBucketRedis
+	minRate  float64
+	maxRate  float64
+	increase float64
+	decrease float64
+	refresh  time.Duration
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	rates map[string]learnedRate
+}
+
+type learnedRate struct {
+	rate    float64
+	fetched time.Time
+}
+
+// AdaptiveOption configures an Adaptive limiter
+type AdaptiveOption func(*Adaptive)
+
+// WithRateBounds sets the range the learned rate is kept in (default is a tenth to ten times the initial rate)
+func WithRateBounds(minRate, maxRate float64) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.minRate = minRate
+		a.maxRate = maxRate
+	}
+}
+
+// WithIncrease sets the requests per second added on every success (default is 1% of the initial rate)
+func WithIncrease(step float64) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.increase = step
+	}
+}
+
+// WithDecrease sets the factor the rate is multiplied by on throttling or slowness (default is 0.5)
+func WithDecrease(factor float64) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.decrease = factor
+	}
+}
+
+// WithRateRefresh sets how long an instance caches the learned rate of a key (default is 1 second)
+func WithRateRefresh(d time.Duration) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.refresh = d
+	}
+}
+
+// WithRateTTL sets how long an unused learned rate is kept in Redis (default is 24 hours)
+func WithRateTTL(d time.Duration) AdaptiveOption {
+	return func(a *Adaptive) {
+		a.ttl = d
+	}
+}
+
+// NewAdaptive creates an Adaptive limiter that starts every key at the rate of lb
+func NewAdaptive(lb *LeakyBucketRedis, opts ...AdaptiveOption) *Adaptive {
+	a := &Adaptive{
+		lb:       lb,
+		minRate:  lb.rate / 10,
+		maxRate:  lb.rate * 10,
+		increase: lb.rate / 100,
+		decrease: 0.5,
+		refresh:  time.Second,
+		ttl:      24 * time.Hour,
+		rates:    make(map[string]learnedRate),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// rateKey is the Redis key holding the learned rate of key
+func rateKey(key string) string {
+	return key + ":aimd_rate"
+}
+
+// Rate returns the current learned rate for key
+func (a *Adaptive) Rate(ctx context.Context, key string) (float64, error) {
+	a.mu.Lock()
+	cached, ok := a.rates[key]
+	a.mu.Unlock()
+	if ok && time.Since(cached.fetched) < a.refresh {
+		return cached.rate, nil
+	}
+
+	rate := a.lb.rate
+	val, err := redisCall(ctx, a.lb, func(ctx context.Context) (string, error) {
+		return a.lb.client.Get(ctx, rateKey(key)).Result()
+	})
+	switch {
+	case err == nil:
+		if parsed, perr := strconv.ParseFloat(val, 64); perr == nil && parsed > 0 {
+			rate = parsed
+		}
+	case !errors.Is(err, redis.Nil):
+		if ok {
+			return cached.rate, err
+		}
+		return rate, err
+	}
+
+	a.remember(key, rate)
+	return rate, nil
+}
+
+// remember caches the learned rate of key, dropping the rates that are due
+// for a refresh anyway once the cache grows large
+func (a *Adaptive) remember(key string, rate float64) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.rates[key]; !ok && len(a.rates) >= rateSweepSize {
+		for k, cached := range a.rates {
+			if now.Sub(cached.fetched) >= a.refresh {
+				delete(a.rates, k)
+			}
+		}
+	}
+	a.rates[key] = learnedRate{rate: rate, fetched: now}
+}
+
+// Allow checks if a request for key is permitted at the currently learned rate
+func (a *Adaptive) Allow(ctx context.Context, key string) (*Result, error) {
+	return a.Reserve(ctx, key, 0)
+}
+
+// Reserve is like LeakyBucketRedis.Reserve at the currently learned rate
+func (a *Adaptive) Reserve(ctx context.Context, key string, maxWait time.Duration) (*Result, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	// On Redis errors the last known or initial rate is used
+	rate, _ := a.Rate(ctx, key)
+	return a.lb.eval(ctx, key, call{rate: rate, maxWait: maxWait, cost: 1})
+}
+
+// Wait blocks until a request for key is permitted or the context is cancelled.
+// Like LeakyBucketRedis.Wait it reserves a slot once and sleeps until it is due.
+func (a *Adaptive) Wait(ctx context.Context, key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	rate, _ := a.Rate(ctx, key)
+	return a.lb.waitCall(ctx, key, call{rate: rate, cost: 1})
+}
+
+// WaitTimeout is like Wait but gives up after timeout
+func (a *Adaptive) WaitTimeout(ctx context.Context, key string, timeout time.Duration) error {
+	tCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return a.Wait(tCtx, key)
+}
+
+// Release hands n unused permits for key back to the bucket at the learned rate
+func (a *Adaptive) Release(ctx context.Context, key string, n int) error {
+	rate, _ := a.Rate(ctx, key)
+	return a.lb.release(ctx, key, rate, n)
+}
+
+// Feedback adjusts the learned rate of key according to outcome. The update
+// is applied atomically in Redis and is visible to all instances.
+func (a *Adaptive) Feedback(ctx context.Context, key string, outcome Outcome) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+
+	// ARGV[1]: initial rate, ARGV[2]: min, ARGV[3]: max
+	// ARGV[4]: additive step, ARGV[5]: multiplicative factor, ARGV[6]: success (1) or not (0)
+	// ARGV[7]: ttl (seconds)
+	script := `
+		local rate = tonumber(redis.call('GET', KEYS[1]) or ARGV[1])
+		if ARGV[6] == '1' then
+			rate = rate + tonumber(ARGV[4])
+		else
+			rate = rate * tonumber(ARGV[5])
+		end
+		rate = math.min(math.max(rate, tonumber(ARGV[2])), tonumber(ARGV[3]))
+		redis.call('SET', KEYS[1], tostring(rate), 'EX', tonumber(ARGV[7]))
+		return tostring(rate)
+	`
+
+	success := 0
+	if outcome == OutcomeSuccess {
+		success = 1
+	}
+
+	val, err := redisCall(ctx, a.lb, func(ctx context.Context) (string, error) {
+		return a.lb.client.Eval(ctx, script, []string{rateKey(key)},
+			a.lb.rate, a.minRate, a.maxRate, a.increase, a.decrease, success, max(1, int(a.ttl.Seconds()))).Text()
+	})
+	if err != nil {
+		return err
+	}
+
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return err
+	}
+	a.remember(key, rate)
+	return nil
+}
@@ -0,0 +1,152 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdaptive_Feedback(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	key := "adaptive_feedback"
+
+	a := NewAdaptive(New(client, 10.0), WithIncrease(1), WithDecrease(0.5), WithRateBounds(2, 12), WithRateRefresh(0))
+
+	steps := []struct {
+		outcome Outcome
+		want    float64
+	}{
+		{OutcomeSuccess, 11},
+		{OutcomeSuccess, 12},
+		{OutcomeSuccess, 12}, // clamped at max
+		{OutcomeThrottled, 6},
+		{OutcomeSlow, 3},
+		{OutcomeThrottled, 2}, // clamped at min
+	}
+
+	for i, step := range steps {
+		if err := a.Feedback(ctx, key, step.outcome); err != nil {
+			t.Fatalf("Feedback failed: %v", err)
+		}
+		rate, err := a.Rate(ctx, key)
+		if err != nil {
+			t.Fatalf("Rate failed: %v", err)
+		}
+		if math.Abs(rate-step.want) > 1e-9 {
+			t.Errorf("Step %d: Expected rate %v, got %v", i+1, step.want, rate)
+		}
+	}
+
+	res, err := a.Allow(ctx, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Limit != 2 {
+		t.Errorf("Expected Allow to use the learned rate 2, got %v", res.Limit)
+	}
+}
+
+func TestAdaptive_Wait(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	a := NewAdaptive(New(client, 10.0), WithRateRefresh(0))
+	ctx := context.Background()
+	key := "adaptive_wait"
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := a.Wait(ctx, key); err != nil {
+			t.Fatalf("Wait %d: Unexpected error: %v", i+1, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected waits to be spaced out, took %v", elapsed)
+	}
+
+	// A cancelled wait hands its slot back
+	cctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := a.Wait(cctx, key); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	res, _ := a.Reserve(ctx, key, 150*time.Millisecond)
+	if !res.Allowed {
+		t.Errorf("Expected the released slot to be available, got wait %v", res.WaitTime)
+	}
+}
+
+func TestAdaptive_SharedAcrossInstances(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	key := "adaptive_shared"
+
+	podA := NewAdaptive(New(client, 10.0), WithRateRefresh(0))
+	podB := NewAdaptive(New(client, 10.0), WithRateRefresh(0))
+
+	if err := podA.Feedback(ctx, key, OutcomeThrottled); err != nil {
+		t.Fatalf("Feedback failed: %v", err)
+	}
+
+	rate, _ := podB.Rate(ctx, key)
+	if rate != 5 {
+		t.Errorf("Expected the other instance to see the learned rate 5, got %v", rate)
+	}
+
+	// Unknown keys start at the initial rate
+	if rate, _ := podB.Rate(ctx, "adaptive_unknown"); rate != 10 {
+		t.Errorf("Expected initial rate 10, got %v", rate)
+	}
+}
+
+func TestAdaptive_DropsStaleRates(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	a := NewAdaptive(New(client, 10.0), WithRateRefresh(time.Minute))
+	ctx := context.Background()
+
+	stale := time.Now().Add(-time.Hour)
+	for i := 0; i < rateSweepSize; i++ {
+		a.rates[fmt.Sprintf("adaptive_stale_%d", i)] = learnedRate{rate: 10, fetched: stale}
+	}
+	a.Rate(ctx, "adaptive_fresh")
+
+	if _, ok := a.rates["adaptive_fresh"]; !ok || len(a.rates) != 1 {
+		t.Errorf("Expected only the fresh rate to stay cached, got %d cached", len(a.rates))
+	}
+}
+
+func TestTransport_AdaptiveFeedback(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	a := NewAdaptive(New(client, 100.0, WithBurst(10)), WithRateRefresh(0))
+	httpClient := &http.Client{Transport: NewTransport(a, ExtractHost, WithUpstreamPause(false))}
+
+	resp, err := httpClient.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	rate, _ := a.Rate(context.Background(), ExtractHost(resp.Request))
+	if rate != 50 {
+		t.Errorf("Expected upstream 429 to halve the rate to 50, got %v", rate)
+	}
+}
@@ -0,0 +1,186 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultChunkSize is the number of bytes fetched from the bucket at once by
+// the bandwidth wrappers. Chunks larger than the burst of the limiter are
+// halved until the bucket can grant them.
+const DefaultChunkSize = 16 * 1024
+
+// BandwidthOption configures the bandwidth-limiting wrappers
+type BandwidthOption func(*bandwidth)
+
+// WithChunkSize sets how many bytes are fetched from the bucket per Redis round trip (default is DefaultChunkSize).
+// Larger chunks mean fewer round trips but a coarser rate.
+func WithChunkSize(n int) BandwidthOption {
+	return func(b *bandwidth) {
+		if n < 1 {
+			n = 1
+		}
+		b.chunk = n
+	}
+}
+
+// bandwidth hands out byte tokens fetched from a shared bucket in chunks
+type bandwidth struct {
+	ctx     context.Context
+	limiter WeightedLimiter
+	key     string
+	chunk   int
+
+	mu     sync.Mutex
+	tokens int
+}
+
+func newBandwidth(ctx context.Context, limiter WeightedLimiter, key string, opts []BandwidthOption) *bandwidth {
+	b := &bandwidth{
+		ctx:     ctx,
+		limiter: limiter,
+		key:     key,
+		chunk:   DefaultChunkSize,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// take blocks until tokens are available and returns how many of the n
+// requested bytes may be transferred now. The caller must report the bytes
+// it actually transferred with consume.
+func (b *bandwidth) take(n int) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.tokens == 0 {
+		err := b.limiter.WaitN(b.ctx, b.key, b.chunk)
+		if errors.Is(err, ErrCostExceedsBurst) && b.chunk > 1 {
+			// The bucket never holds a whole chunk: fetch smaller ones from now on
+			b.chunk /= 2
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		b.tokens = b.chunk
+	}
+	return min(n, b.tokens), nil
+}
+
+func (b *bandwidth) consume(n int) {
+	b.mu.Lock()
+	b.tokens -= min(n, b.tokens)
+	b.mu.Unlock()
+}
+
+// Reader is an io.Reader whose throughput is limited by a shared bucket
+// whose rate is expressed in bytes per second.
+type Reader struct {
+	r  io.Reader
+	bw *bandwidth
+}
+
+// NewReader wraps r so that reads are limited by limiter for key.
+// ctx bounds the waits for tokens.
+func NewReader(ctx context.Context, r io.Reader, limiter WeightedLimiter, key string, opts ...BandwidthOption) *Reader {
+	return &Reader{r: r, bw: newBandwidth(ctx, limiter, key, opts)}
+}
+
+// Read implements io.Reader
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.r.Read(p)
+	}
+	allowed, err := r.bw.take(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p[:allowed])
+	r.bw.consume(n)
+	return n, err
+}
+
+// Writer is an io.Writer whose throughput is limited by a shared bucket
+// whose rate is expressed in bytes per second.
+type Writer struct {
+	w  io.Writer
+	bw *bandwidth
+}
+
+// NewWriter wraps w so that writes are limited by limiter for key.
+// ctx bounds the waits for tokens.
+func NewWriter(ctx context.Context, w io.Writer, limiter WeightedLimiter, key string, opts ...BandwidthOption) *Writer {
+	return &Writer{w: w, bw: newBandwidth(ctx, limiter, key, opts)}
+}
+
+// Write implements io.Writer. Large writes are split into chunks.
+func (w *Writer) Write(p []byte) (int, error) {
+	return limitedWrite(w.w, w.bw, p)
+}
+
+func limitedWrite(w io.Writer, bw *bandwidth, p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		allowed, err := bw.take(len(p) - written)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.Write(p[written : written+allowed])
+		bw.consume(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// responseWriter limits the bandwidth of a response body
+type responseWriter struct {
+	http.ResponseWriter
+	bw *bandwidth
+}
+
+// NewResponseWriter wraps w so that the response body is limited by limiter
+// for key. The wrapper supports http.ResponseController through Unwrap.
+func NewResponseWriter(ctx context.Context, w http.ResponseWriter, limiter WeightedLimiter, key string, opts ...BandwidthOption) http.ResponseWriter {
+	return &responseWriter{ResponseWriter: w, bw: newBandwidth(ctx, limiter, key, opts)}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	return limitedWrite(w.ResponseWriter, w.bw, p)
+}
+
+// Flush implements http.Flusher when the underlying writer does
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// BandwidthMiddleware returns an http.Handler middleware that limits the
+// bytes per second of response bodies, sharing one bucket per key across all
+// instances. Requests for which extractor returns an empty key are not limited.
+func BandwidthMiddleware(limiter WeightedLimiter, extractor KeyExtractor, opts ...BandwidthOption) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := extractor(r); key != "" {
+				w = NewResponseWriter(r.Context(), w, limiter, key, opts...)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package leaky_bucket_redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingLimiter counts the WaitN round trips made to the wrapped limiter
+type countingLimiter struct {
+	WeightedLimiter
+	calls atomic.Int32
+}
+
+func (c *countingLimiter) WaitN(ctx context.Context, key string, n int) error {
+	c.calls.Add(1)
+	return c.WeightedLimiter.WaitN(ctx, key, n)
+}
+
+func TestLeakyBucketRedis_AllowN(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 10.0, WithBurst(10))
+	ctx := context.Background()
+
+	if res, _ := lb.AllowN(ctx, "allow_n", 7); !res.Allowed || res.Remaining != 3 {
+		t.Errorf("Expected 7 of 10 permits to be granted with 3 remaining, got %+v", res)
+	}
+	res, _ := lb.AllowN(ctx, "allow_n", 4)
+	if res.Allowed {
+		t.Error("Expected request for 4 permits to be denied with 3 left")
+	}
+	if res.WaitTime < 50*time.Millisecond || res.WaitTime > 100*time.Millisecond {
+		t.Errorf("Expected wait around 100ms for the missing permit, got %v", res.WaitTime)
+	}
+
+	if _, err := lb.AllowN(ctx, "allow_n", 11); !errors.Is(err, ErrCostExceedsBurst) {
+		t.Errorf("Expected ErrCostExceedsBurst, got %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	// 40 KB/s with 4 KB chunks: 16 KB takes one burst plus three 100ms refills
+	limiter := &countingLimiter{WeightedLimiter: New(client, 40000, WithBurst(4000))}
+	data := bytes.Repeat([]byte("x"), 16000)
+
+	start := time.Now()
+	r := NewReader(context.Background(), bytes.NewReader(data), limiter, "reader", WithChunkSize(4000))
+	got := make([]byte, len(data))
+	_, err := io.ReadFull(r, got)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected %d bytes, got %d", len(data), len(got))
+	}
+	if elapsed < 250*time.Millisecond {
+		t.Errorf("Expected reading to be throttled, took %v", elapsed)
+	}
+	if calls := limiter.calls.Load(); calls != 4 {
+		t.Errorf("Expected one round trip per 4 KB chunk (4), got %d", calls)
+	}
+}
+
+func TestWriter_ChunkLargerThanBurst(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	// The default chunk of 16 KiB does not fit in a burst of 1000 bytes
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, New(client, 100000, WithBurst(1000)), "small_burst")
+
+	if n, err := w.Write([]byte("ten bytes!")); err != nil || n != 10 {
+		t.Fatalf("Expected the write to succeed, wrote %d with %v", n, err)
+	}
+	if n, err := w.Write(bytes.Repeat([]byte("x"), 3000)); err != nil || n != 3000 {
+		t.Fatalf("Expected the write to succeed, wrote %d with %v", n, err)
+	}
+	if chunk := w.bw.chunk; chunk > 1000 {
+		t.Errorf("Expected the chunk to shrink to the burst, got %d", chunk)
+	}
+	if buf.Len() != 3010 {
+		t.Errorf("Expected 3010 bytes written, got %d", buf.Len())
+	}
+}
+
+func TestWriter_ContextCancelled(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	w := NewWriter(ctx, &buf, New(client, 1000, WithBurst(1000)), "writer", WithChunkSize(1000))
+
+	n, err := w.Write(bytes.Repeat([]byte("x"), 5000))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	if n != buf.Len() || n >= 5000 {
+		t.Errorf("Expected a partial write matching the buffer, wrote %d of which %d buffered", n, buf.Len())
+	}
+}
+
+func TestBandwidthMiddleware(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	body := strings.Repeat("y", 12000)
+	mw := BandwidthMiddleware(New(client, 40000, WithBurst(4000)), ExtractHeader("X-Customer"), WithChunkSize(4000))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+		http.NewResponseController(w).Flush()
+	}))
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Customer", "acme")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Body.String() != body {
+		t.Errorf("Expected full body of %d bytes, got %d", len(body), rec.Body.Len())
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected body to be throttled, took %v", elapsed)
+	}
+	if !rec.Flushed {
+		t.Error("Expected Flush to reach the underlying writer")
+	}
+
+	// Requests without a key are not limited
+	start = time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected unkeyed request to be unlimited, took %v", elapsed)
+	}
+}
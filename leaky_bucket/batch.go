@@ -0,0 +1,152 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxBatchSize is the number of collected checks that are sent without waiting for the window to end
+const maxBatchSize = 256
+
+// WithBatching collects the checks made concurrently within window, such as
+// calls to Allow from many goroutines, and sends them to Redis in a single
+// pipeline. Each check waits up to window longer, in exchange for far fewer
+// round trips under load. Windows of 100-500µs work well.
+func WithBatching(window time.Duration) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.batcher = &batcher{lb: lb, window: window}
+	}
+}
+
+// batcher collects checks and runs them in pipelines
+type batcher struct {
+	lb     *LeakyBucketRedis
+	window time.Duration
+
+	mu      sync.Mutex
+	pending []*batchCall
+}
+
+// batchCall is a check waiting for its batch to be sent
+type batchCall struct {
+	key      string
+	c        call
+	reserved int
+
+	done  chan struct{}
+	reply []interface{}
+	err   error
+}
+
+// do adds a check to the current batch and waits for its reply
+func (b *batcher) do(ctx context.Context, key string, c call, reserved int) ([]interface{}, error) {
+	bc := &batchCall{key: key, c: c, reserved: reserved, done: make(chan struct{})}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, bc)
+	switch len(b.pending) {
+	case 1:
+		time.AfterFunc(b.window, b.flush)
+	case maxBatchSize:
+		go b.flush()
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-bc.done:
+		return bc.reply, bc.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush sends the checks collected so far
+func (b *batcher) flush() {
+	b.mu.Lock()
+	calls := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	if len(calls) == 0 {
+		return
+	}
+
+	// The batch outlives the individual callers, who stop waiting when their context ends
+	ctx := context.Background()
+	run := b.script
+	if _, ok := b.lb.client.(*redis.ClusterClient); ok {
+		// The keys of a batch may live on different nodes
+		run = b.pipeline
+	}
+
+	replies, err := run(ctx, calls)
+	if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") {
+		replies, err = run(ctx, calls)
+	}
+	if err == nil && len(replies) != len(calls) {
+		err = fmt.Errorf("%w: %d replies for %d checks", ErrScriptReply, len(replies), len(calls))
+	}
+
+	for i, bc := range calls {
+		if err != nil {
+			bc.err = err
+		} else if reply, ok := replies[i].([]interface{}); ok {
+			bc.reply = reply
+		} else {
+			bc.err = fmt.Errorf("%w: %v", ErrScriptReply, replies[i])
+		}
+		close(bc.done)
+	}
+}
+
+// gcraBatchCall checks several buckets in order; KEYS may repeat.
+// ARGV[1]: burst, ARGV[2]: now, followed by rate, max_wait, reserved and cost for each key
+var gcraBatchCall = redis.NewScript(gcraScript + `
+	local burst = tonumber(ARGV[1])
+	local now = tonumber(ARGV[2])
+	local results = {}
+	for i, key in ipairs(KEYS) do
+		local a = 2 + (i - 1) * 4
+		results[i] = gcra(key, tonumber(ARGV[a + 1]), burst, now, tonumber(ARGV[a + 2]), tonumber(ARGV[a + 3]), tonumber(ARGV[a + 4]))
+	end
+	return results
+`)
+
+// script runs calls with a single invocation of gcraBatchCall
+func (b *batcher) script(ctx context.Context, calls []*batchCall) ([]interface{}, error) {
+	keys := make([]string, len(calls))
+	args := make([]interface{}, 0, 2+4*len(calls))
+	args = append(args, b.lb.burst, float64(time.Now().UnixNano())/1e9)
+	for i, bc := range calls {
+		keys[i] = bc.key
+		args = append(args, bc.c.rate, bc.c.maxWait.Seconds(), bc.reserved, bc.c.cost)
+	}
+	return gcraBatchCall.Run(ctx, b.lb.client, keys, args...).Slice()
+}
+
+// pipeline runs calls by script SHA in one pipeline, loading the script if Redis lost it
+func (b *batcher) pipeline(ctx context.Context, calls []*batchCall) ([]interface{}, error) {
+	cmds := make([]*redis.Cmd, len(calls))
+	_, err := b.lb.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, bc := range calls {
+			cmds[i] = gcraCall.EvalSha(ctx, pipe, []string{bc.key}, b.lb.gcraArgs(bc.c, bc.reserved)...)
+		}
+		return nil
+	})
+	if err != nil {
+		if redis.HasErrorPrefix(err, "NOSCRIPT") {
+			gcraCall.Load(ctx, b.lb.client)
+		}
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i, cmd := range cmds {
+		replies[i] = cmd.Val()
+	}
+	return replies, nil
+}
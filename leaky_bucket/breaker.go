@@ -0,0 +1,197 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FailurePolicy decides how requests are answered when Redis cannot be reached
+type FailurePolicy int
+
+const (
+	// FailOpen allows requests while Redis is unavailable (default).
+	FailOpen FailurePolicy = iota
+	// FailClosed denies requests while Redis is unavailable. Wait returns an error instead of blocking.
+	FailClosed
+)
+
+// WithFailurePolicy sets how requests are answered when Redis cannot be reached (default is FailOpen)
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.failurePolicy = policy
+	}
+}
+
+// waitFailure applies the failure policy to a Wait that could not reach
+// Redis: FailOpen lets it through and FailClosed returns the error
+func (lb *LeakyBucketRedis) waitFailure(err error) error {
+	if lb.failurePolicy == FailClosed {
+		return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	return nil
+}
+
+// failedClosed reports whether a Wait must return err instead of waiting:
+// either there is no Result, or the failure policy denied the request, which
+// says nothing about when a permit will be available.
+func failedClosed(res *Result, err error) bool {
+	return res == nil || (err != nil && !res.Allowed)
+}
+
+// BreakerState is the state of the circuit breaker in front of Redis
+type BreakerState int
+
+const (
+	// BreakerClosed lets calls through to Redis.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen answers calls with the failure policy without calling Redis.
+	BreakerOpen
+	// BreakerHalfOpen probes Redis while calls are still answered with the failure policy.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures the circuit breaker
+type BreakerConfig struct {
+	Failures      int                         // Failures is the number of consecutive failed calls that trips the breaker (default is 5).
+	Latency       time.Duration               // Latency counts calls slower than this as failed; 0 disables the check.
+	Cooldown      time.Duration               // Cooldown is how long the breaker stays open before probing Redis (default is 5s).
+	OnStateChange func(from, to BreakerState) // OnStateChange is called on every transition, if set.
+}
+
+// WithCircuitBreaker trips after consecutive Redis errors or slow calls and
+// then answers with the failure policy without waiting for Redis. After the
+// cooldown Redis is probed with a PING in the background; the breaker closes
+// again once a probe succeeds. Every call to Redis counts, including leases,
+// queue tickets, Pause, Release and Reset; while open, those fail at once.
+func WithCircuitBreaker(config BreakerConfig) Option {
+	return func(lb *LeakyBucketRedis) {
+		if config.Failures < 1 {
+			config.Failures = 5
+		}
+		if config.Cooldown <= 0 {
+			config.Cooldown = 5 * time.Second
+		}
+		lb.breaker = &breaker{lb: lb, config: config}
+	}
+}
+
+// BreakerState returns the state of the circuit breaker, which is always closed if none is configured
+func (lb *LeakyBucketRedis) BreakerState() BreakerState {
+	if lb.breaker == nil {
+		return BreakerClosed
+	}
+	lb.breaker.mu.Lock()
+	defer lb.breaker.mu.Unlock()
+	return lb.breaker.state
+}
+
+// breaker is a circuit breaker in front of the Redis calls of a limiter
+type breaker struct {
+	lb     *LeakyBucketRedis
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// ready reports whether a call may go to Redis, and starts a probe once the cooldown is over
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	switch b.state {
+	case BreakerClosed:
+		b.mu.Unlock()
+		return true
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.Cooldown {
+			b.mu.Unlock()
+			return false
+		}
+		notify := b.transition(BreakerHalfOpen)
+		b.mu.Unlock()
+		notify()
+		go b.probe()
+		return false
+	}
+	b.mu.Unlock()
+	return false
+}
+
+// record counts the outcome of a call made while the breaker was closed
+func (b *breaker) record(err error, elapsed time.Duration) {
+	failed := err != nil || (b.config.Latency > 0 && elapsed > b.config.Latency)
+
+	b.mu.Lock()
+	if b.state != BreakerClosed {
+		b.mu.Unlock()
+		return
+	}
+	if !failed {
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+	b.failures++
+	notify := func() {}
+	if b.failures >= b.config.Failures {
+		notify = b.transition(BreakerOpen)
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// probe pings Redis and closes the breaker if it answers in time
+func (b *breaker) probe() {
+	timeout := b.config.Cooldown
+	if b.config.Latency > 0 {
+		timeout = b.config.Latency
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := b.lb.client.Ping(ctx).Err()
+	healthy := err == nil && (b.config.Latency == 0 || time.Since(start) <= b.config.Latency)
+
+	b.mu.Lock()
+	var notify func()
+	if healthy {
+		notify = b.transition(BreakerClosed)
+	} else {
+		notify = b.transition(BreakerOpen)
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// transition moves the breaker to state and returns the function reporting the
+// change, to be called once the lock is released. b.mu must be held.
+func (b *breaker) transition(to BreakerState) func() {
+	from := b.state
+	b.state = to
+	b.failures = 0
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+
+	if b.config.OnStateChange == nil || from == to {
+		return func() {}
+	}
+	return func() { b.config.OnStateChange(from, to) }
+}
@@ -0,0 +1,197 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCircuitBreaker_TripsAndRecovers(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr:        s.Addr(),
+		DialTimeout: 50 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	defer client.Close()
+
+	var mu sync.Mutex
+	var transitions []string
+	lb := New(client, 100.0, WithFailurePolicy(FailClosed), WithCircuitBreaker(BreakerConfig{
+		Failures: 2,
+		Cooldown: 100 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			mu.Lock()
+			transitions = append(transitions, from.String()+"->"+to.String())
+			mu.Unlock()
+		},
+	}))
+	ctx := context.Background()
+	key := "breaker"
+
+	if res, _ := lb.Allow(ctx, key); !res.Allowed {
+		t.Fatal("Expected request to be allowed while Redis is up")
+	}
+
+	s.Close()
+	for i := 0; i < 2; i++ {
+		if res, _ := lb.Allow(ctx, key); res.Allowed {
+			t.Error("Expected FailClosed to deny requests while Redis is down")
+		}
+	}
+	if lb.BreakerState() != BreakerOpen {
+		t.Fatalf("Expected breaker to be open after 2 failures, got %s", lb.BreakerState())
+	}
+
+	start := time.Now()
+	if res, _ := lb.Allow(ctx, key); res.Allowed {
+		t.Error("Expected open breaker to apply the failure policy")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Millisecond {
+		t.Errorf("Expected open breaker to answer without calling Redis, took %v", elapsed)
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	// The first call after the cooldown starts the probe
+	lb.Allow(ctx, key)
+	deadline := time.Now().Add(time.Second)
+	for lb.BreakerState() != BreakerClosed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if res, _ := lb.Allow(ctx, key); !res.Allowed {
+		t.Error("Expected requests to reach Redis again after a successful probe")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("Transition %d: Expected %s, got %s", i, want[i], transitions[i])
+		}
+	}
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr:        s.Addr(),
+		DialTimeout: 50 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	defer client.Close()
+
+	lb := New(client, 100.0, WithCircuitBreaker(BreakerConfig{Failures: 1, Cooldown: 50 * time.Millisecond}))
+	ctx := context.Background()
+
+	s.Close()
+	if res, _ := lb.Allow(ctx, "breaker_probe"); !res.Allowed {
+		t.Error("Expected FailOpen to allow requests while Redis is down")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	lb.Allow(ctx, "breaker_probe")
+	time.Sleep(100 * time.Millisecond)
+
+	if lb.BreakerState() != BreakerOpen {
+		t.Errorf("Expected breaker to open again after a failed probe, got %s", lb.BreakerState())
+	}
+}
+
+func TestFailurePolicy_Wait(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	modes := map[string][]Option{
+		"reserve": nil,
+		"wakeups": {WithWakeups()},
+		"queue":   {WithQueue(0)},
+	}
+	for name, opts := range modes {
+		open := New(client, 10.0, opts...)
+		if err := open.Wait(context.Background(), "wait_policy"); err != nil {
+			t.Errorf("%s: Expected FailOpen to let Wait through, got %v", name, err)
+		}
+
+		// Without a deadline, Wait must not block on a Redis that cannot answer
+		closed := New(client, 10.0, append(opts, WithFailurePolicy(FailClosed))...)
+		done := make(chan error, 1)
+		go func() { done <- closed.Wait(context.Background(), "wait_policy") }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrBackendUnavailable) {
+				t.Errorf("%s: Expected ErrBackendUnavailable, got %v", name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: Expected FailClosed Wait to return while Redis is down", name)
+		}
+	}
+}
+
+func TestCircuitBreaker_AllScripts(t *testing.T) {
+	calls := map[string]func(lb *LeakyBucketRedis) error{
+		"lease": func(lb *LeakyBucketRedis) error {
+			_, err := lb.Allow(context.Background(), "breaker_all")
+			return err
+		},
+		"queue": func(lb *LeakyBucketRedis) error {
+			return lb.Wait(context.Background(), "breaker_all")
+		},
+		"pause": func(lb *LeakyBucketRedis) error {
+			return lb.Pause(context.Background(), "breaker_all", time.Second)
+		},
+		"release": func(lb *LeakyBucketRedis) error {
+			return lb.Release(context.Background(), "breaker_all", 1)
+		},
+		"reset": func(lb *LeakyBucketRedis) error {
+			return lb.Reset(context.Background(), "breaker_all")
+		},
+	}
+	for name, call := range calls {
+		client := createTestClient(t)
+		client.Close() // Force fail
+
+		lb := New(client, 10.0, WithFailurePolicy(FailClosed), WithLeasing(5, time.Second), WithQueue(0),
+			WithCircuitBreaker(BreakerConfig{Failures: 2, Cooldown: time.Minute}))
+		for i := 0; i < 2; i++ {
+			if err := call(lb); err == nil {
+				t.Errorf("%s: Expected an error while Redis is down", name)
+			}
+		}
+		if lb.BreakerState() != BreakerOpen {
+			t.Errorf("%s: Expected the failures to open the breaker, got %s", name, lb.BreakerState())
+		}
+	}
+}
+
+func TestCircuitBreaker_NilReply(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 10.0, WithQueue(1), WithCircuitBreaker(BreakerConfig{Failures: 1}))
+	a := NewAdaptive(lb, WithRateRefresh(0))
+
+	// Missing keys and full queues are answered with nil replies
+	a.Rate(context.Background(), "breaker_nil")
+	if _, err := lb.enqueue(context.Background(), "breaker_nil"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := lb.enqueue(context.Background(), "breaker_nil"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if lb.BreakerState() != BreakerClosed {
+		t.Errorf("Expected nil replies not to count as failures, got %s", lb.BreakerState())
+	}
+}
@@ -0,0 +1,181 @@
+package leaky_bucket_redis
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule matches requests for allowlists and denylists.
+// key is the value returned by the middleware's KeyExtractor.
+type Rule func(r *http.Request, key string) bool
+
+// MatchKeys returns a Rule that matches requests whose rate limiting key is one of keys
+func MatchKeys(keys ...string) Rule {
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	return func(r *http.Request, key string) bool {
+		_, ok := set[key]
+		return ok
+	}
+}
+
+// MatchCIDRs returns a Rule that matches requests whose peer address,
+// r.RemoteAddr, falls into one of the prefixes. Forwarding headers are ignored
+// because any client can set them; use MatchCIDRsBehind behind a reverse proxy.
+// Use netip.MustParsePrefix to build prefixes from configuration strings.
+func MatchCIDRs(prefixes ...netip.Prefix) Rule {
+	return MatchCIDRsBehind(nil, prefixes...)
+}
+
+// MatchCIDRsBehind is like MatchCIDRs for servers behind reverse proxies.
+// X-Forwarded-For is only honored when the peer is in trusted: the chain is
+// read from the right and the first address outside trusted is the client.
+func MatchCIDRsBehind(trusted []netip.Prefix, prefixes ...netip.Prefix) Rule {
+	return func(r *http.Request, key string) bool {
+		addr, ok := clientAddr(r, trusted)
+		if !ok {
+			return false
+		}
+		return containsAddr(prefixes, addr)
+	}
+}
+
+// MatchHeader returns a Rule that matches requests carrying one of tokens in the named header.
+// Tokens are compared in constant time.
+func MatchHeader(name string, tokens ...string) Rule {
+	return func(r *http.Request, key string) bool {
+		v := r.Header.Get(name)
+		if v == "" {
+			return false
+		}
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(v), []byte(t)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WithSkipper sets a function that exempts matching requests from limiting entirely.
+// Skipped requests are not counted and receive no rate limit headers.
+func WithSkipper(fn func(r *http.Request) bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.skipper = fn
+	}
+}
+
+// WithAllowlist exempts requests matching any of rules from limiting.
+// It can be given several times; the rules accumulate.
+func WithAllowlist(rules ...Rule) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.allowlist = append(c.allowlist, rules...)
+	}
+}
+
+// WithDenylist rejects requests matching any of rules with 403 Forbidden.
+// The denylist is checked before the allowlist and the bypass header.
+func WithDenylist(rules ...Rule) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.denylist = append(c.denylist, rules...)
+	}
+}
+
+// WithBypassToken exempts requests that carry a valid token created by
+// SignBypassToken in the named header. Tokens older than maxAge are rejected.
+// It is meant for load tests that must not be throttled.
+func WithBypassToken(header string, secret []byte, maxAge time.Duration) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.bypassHeader = header
+		c.bypassSecret = secret
+		c.bypassMaxAge = maxAge
+	}
+}
+
+// SignBypassToken creates a bypass token for WithBypassToken issued at t.
+// The token has the form "<unix seconds>.<base64url HMAC-SHA256>".
+func SignBypassToken(secret []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return ts + "." + signBypass(secret, ts)
+}
+
+func signBypass(secret []byte, ts string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// bypass reports whether r carries a valid bypass token
+func (c *middlewareConfig) bypass(r *http.Request) bool {
+	if c.bypassHeader == "" || len(c.bypassSecret) == 0 {
+		return false
+	}
+
+	ts, sig, ok := strings.Cut(r.Header.Get(c.bypassHeader), ".")
+	if !ok {
+		return false
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(issued, 0))
+	if age > c.bypassMaxAge || age < -c.bypassMaxAge {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signBypass(c.bypassSecret, ts)))
+}
+
+// matchAny reports whether any of rules matches r
+func matchAny(rules []Rule, r *http.Request, key string) bool {
+	for _, rule := range rules {
+		if rule(r, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client that sent r, following
+// X-Forwarded-For only through the trusted proxies
+func clientAddr(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && containsAddr(trusted, addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return addr, true
+		}
+		addr = hop.Unmap()
+	}
+	return addr, true
+}
+
+// containsAddr reports whether any of prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package leaky_bucket_redis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// secondResponse sends two identical requests through h and returns the second response
+func secondResponse(h http.Handler, prepare func(r *http.Request)) *httptest.ResponseRecorder {
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		prepare(req)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+	}
+	return rec
+}
+
+func TestMatchCIDRs(t *testing.T) {
+	rule := MatchCIDRs(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32"))
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   bool
+	}{
+		{remote: "10.1.2.3:1234", want: true},
+		{remote: "192.168.1.1:1234", want: false},
+		{remote: "[2001:db8::1]:443", want: true},
+		{remote: "192.168.1.1:1234", xff: "10.0.0.9", want: false}, // Spoofed header
+		{remote: "invalid", want: false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := rule(req, ""); got != tt.want {
+			t.Errorf("MatchCIDRs(%s, xff=%q) = %v, want %v", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestMatchCIDRsBehind(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")}
+	rule := MatchCIDRsBehind(proxies, netip.MustParsePrefix("10.0.0.0/8"))
+
+	tests := []struct {
+		remote string
+		xff    string
+		want   bool
+	}{
+		{remote: "192.168.1.1:1234", xff: "10.0.0.9", want: true},
+		{remote: "192.168.1.1:1234", xff: "10.0.0.9, 192.168.2.2", want: true},
+		{remote: "192.168.1.1:1234", xff: "10.0.0.9, 203.0.113.5", want: false}, // Client spoofed the left entry
+		{remote: "203.0.113.5:1234", xff: "10.0.0.9", want: false},              // Untrusted peer
+		{remote: "10.1.2.3:1234", want: true},
+		{remote: "192.168.1.1:1234", want: false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := rule(req, ""); got != tt.want {
+			t.Errorf("MatchCIDRsBehind(%s, xff=%q) = %v, want %v", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestBypassRules(t *testing.T) {
+	secret := []byte("load-test-secret")
+
+	tests := []struct {
+		name    string
+		opt     MiddlewareOption
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{
+			name:    "skipper",
+			opt:     WithSkipper(func(r *http.Request) bool { return r.Header.Get("X-Health") != "" }),
+			prepare: func(r *http.Request) { r.Header.Set("X-Health", "1") },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "allowlist key",
+			opt:     WithAllowlist(MatchKeys("parity")),
+			prepare: func(r *http.Request) {},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "allowlist cidr",
+			opt:     WithAllowlist(MatchCIDRs(netip.MustParsePrefix("10.0.0.0/8"))),
+			prepare: func(r *http.Request) { r.RemoteAddr = "10.0.0.1:5000" },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "allowlist header token",
+			opt:     WithAllowlist(MatchHeader("X-Service-Token", "internal-svc")),
+			prepare: func(r *http.Request) { r.Header.Set("X-Service-Token", "internal-svc") },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "allowlist miss",
+			opt:     WithAllowlist(MatchHeader("X-Service-Token", "internal-svc")),
+			prepare: func(r *http.Request) { r.Header.Set("X-Service-Token", "guess") },
+			want:    http.StatusTooManyRequests,
+		},
+		{
+			name:    "denylist",
+			opt:     WithDenylist(MatchCIDRs(netip.MustParsePrefix("192.0.2.0/24"))),
+			prepare: func(r *http.Request) { r.RemoteAddr = "192.0.2.10:5000" },
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "bypass token",
+			opt:     WithBypassToken("X-Bypass", secret, time.Minute),
+			prepare: func(r *http.Request) { r.Header.Set("X-Bypass", SignBypassToken(secret, time.Now())) },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "expired bypass token",
+			opt:     WithBypassToken("X-Bypass", secret, time.Minute),
+			prepare: func(r *http.Request) { r.Header.Set("X-Bypass", SignBypassToken(secret, time.Now().Add(-time.Hour))) },
+			want:    http.StatusTooManyRequests,
+		},
+		{
+			name:    "forged bypass token",
+			opt:     WithBypassToken("X-Bypass", secret, time.Minute),
+			prepare: func(r *http.Request) { r.Header.Set("X-Bypass", SignBypassToken([]byte("wrong"), time.Now())) },
+			want:    http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, h := range adapterHandlers(t, tt.opt) {
+				if rec := secondResponse(h, tt.prepare); rec.Code != tt.want {
+					t.Errorf("%s: Expected status %d, got %d", name, tt.want, rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestDenylistPrecedence(t *testing.T) {
+	opts := []MiddlewareOption{
+		WithAllowlist(MatchKeys("parity")),
+		WithDenylist(MatchKeys("parity")),
+	}
+	for name, h := range adapterHandlers(t, opts...) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: Expected denylist to win with 403, got %d", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package leaky_bucket_redis
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// denyCache remembers until when keys are denied, so that repeated requests
+// from a limited client are answered without a round trip to Redis
+type denyCache struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]map[Priority]*denial // Denials by key, then priority
+	expiry  denialHeap                      // The same denials, the first to expire on top
+}
+
+// denial is a cached denial and its position in the expiry heap
+type denial struct {
+	bk    bucketKey
+	until time.Time
+	index int
+}
+
+// denialHeap is a min-heap of denials ordered by expiry, for container/heap
+type denialHeap []*denial
+
+func (h denialHeap) Len() int           { return len(h) }
+func (h denialHeap) Less(i, j int) bool { return h[i].until.Before(h[j].until) }
+
+func (h denialHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *denialHeap) Push(x any) {
+	d := x.(*denial)
+	d.index = len(*h)
+	*h = append(*h, d)
+}
+
+func (h *denialHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return d
+}
+
+// WithDenyCache keeps the denial of up to maxKeys keys in memory and denies
+// further requests for them locally until a permit can be available again.
+// Capacity returned on other instances, e.g. by Reset, is not seen before then.
+// When the cache is full, the denial that expires first makes room.
+func WithDenyCache(maxKeys int) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.denials = &denyCache{maxKeys: max(maxKeys, 1), entries: make(map[string]map[Priority]*denial)}
+	}
+}
+
+// check returns a denied Result if bk is known to be denied beyond maxWait
+func (d *denyCache) check(bk bucketKey, maxWait time.Duration, rate float64) (*Result, bool) {
+	d.mu.Lock()
+	var until time.Time
+	e, ok := d.entries[bk.key][bk.priority]
+	if ok {
+		until = e.until
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	wait := time.Until(until)
+	if wait <= maxWait {
+		return nil, false
+	}
+	return &Result{Allowed: false, WaitTime: wait, Remaining: 0, Limit: rate, Source: SourceDenyCache}, true
+}
+
+// remember records a denial of a request for a single permit
+func (d *denyCache) remember(bk bucketKey, res *Result) {
+	if res.Allowed || res.WaitTime <= 0 {
+		return
+	}
+	now := time.Now()
+	until := now.Add(res.WaitTime)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[bk.key][bk.priority]; ok {
+		e.until = until
+		heap.Fix(&d.expiry, e.index)
+		return
+	}
+
+	// Drop expired denials, then the one closest to expiry if still full
+	for d.expiry.Len() > 0 && (!d.expiry[0].until.After(now) || d.expiry.Len() >= d.maxKeys) {
+		d.remove(d.expiry[0])
+	}
+
+	e := &denial{bk: bk, until: until}
+	heap.Push(&d.expiry, e)
+	byPriority := d.entries[bk.key]
+	if byPriority == nil {
+		byPriority = make(map[Priority]*denial, 1)
+		d.entries[bk.key] = byPriority
+	}
+	byPriority[bk.priority] = e
+}
+
+// forget drops the denials of key for all priorities
+func (d *denyCache) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, e := range d.entries[key] {
+		d.remove(e)
+	}
+}
+
+// remove drops e from the cache. d.mu must be held.
+func (d *denyCache) remove(e *denial) {
+	heap.Remove(&d.expiry, e.index)
+	byPriority := d.entries[e.bk.key]
+	delete(byPriority, e.bk.priority)
+	if len(byPriority) == 0 {
+		delete(d.entries, e.bk.key)
+	}
+}
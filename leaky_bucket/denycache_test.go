@@ -0,0 +1,87 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDenyCache_AnswersLocally(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	lb := New(client, 10.0, WithDenyCache(100))
+	ctx := context.Background()
+	key := "deny_cache"
+
+	lb.Allow(ctx, key)
+	if res, _ := lb.Allow(ctx, key); res.Allowed {
+		t.Fatal("Expected second request to be denied")
+	}
+
+	commands := s.CommandCount()
+	for i := 0; i < 5; i++ {
+		res, _ := lb.Allow(ctx, key)
+		if res.Allowed || res.WaitTime <= 0 || res.WaitTime > 100*time.Millisecond {
+			t.Errorf("Expected a local denial with the remaining wait, got %+v", res)
+		}
+	}
+	if got := s.CommandCount(); got != commands {
+		t.Errorf("Expected denied requests not to reach Redis, got %d more commands", got-commands)
+	}
+
+	// A reservation that can wait for the permit still goes to Redis
+	if res, _ := lb.Reserve(ctx, key, time.Second); !res.Allowed {
+		t.Errorf("Expected reservation within maxWait to be granted, got %+v", res)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if res, _ := lb.Allow(ctx, key); !res.Allowed {
+		t.Error("Expected request to be allowed once the denial expired")
+	}
+}
+
+func TestDenyCache_Bounded(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithDenyCache(2))
+	ctx := context.Background()
+
+	for _, key := range []string{"deny_a", "deny_b", "deny_c"} {
+		lb.Allow(ctx, key)
+		lb.Allow(ctx, key)
+	}
+	if n := lb.denials.expiry.Len(); n != 2 {
+		t.Errorf("Expected the cache to hold at most 2 keys, got %d", n)
+	}
+
+	lb.Reset(ctx, "deny_c")
+	if res, _ := lb.Allow(ctx, "deny_c"); !res.Allowed {
+		t.Error("Expected Reset to clear the cached denial")
+	}
+}
+
+func TestDenyCache_EvictsFirstToExpire(t *testing.T) {
+	d := &denyCache{maxKeys: 2, entries: make(map[string]map[Priority]*denial)}
+	deny := func(key string, wait time.Duration) {
+		d.remember(bucketKey{key: key}, &Result{WaitTime: wait})
+	}
+
+	deny("long", time.Minute)
+	deny("short", time.Second)
+	deny("new", time.Minute)
+
+	if _, ok := d.check(bucketKey{key: "short"}, 0, 1); ok {
+		t.Error("Expected the denial closest to expiry to be evicted")
+	}
+	for _, key := range []string{"long", "new"} {
+		if _, ok := d.check(bucketKey{key: key}, 0, 1); !ok {
+			t.Errorf("Expected the denial of %s to be kept", key)
+		}
+	}
+}
@@ -3,48 +3,60 @@ package leaky_bucket_redis
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
 
+// EchoDeniedHandler writes the response for a rate-limited Echo request.
+// The returned error is passed back to Echo's error handling.
+type EchoDeniedHandler func(c echo.Context, res *Result) error
+
+// WithEchoDeniedHandler sets an Echo-native handler for rate-limited requests.
+// It takes precedence over WithErrorHandler in EchoMiddleware and is ignored by the other adapters.
+func WithEchoDeniedHandler(h EchoDeniedHandler) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.echoDenied = h
+	}
+}
+
+// defaultEchoDenied writes the default JSON body for a rate-limited Echo request
+func defaultEchoDenied(c echo.Context, res *Result) error {
+	return c.JSON(http.StatusTooManyRequests, map[string]string{
+		"error":       "Rate limit exceeded",
+		"retry_after": fmt.Sprintf("%.3fs", res.WaitTime.Seconds()),
+	})
+}
+
 // EchoMiddleware returns an Echo-compatible middleware
 func EchoMiddleware(limiter Limiter, extractor KeyExtractor, opts ...MiddlewareOption) echo.MiddlewareFunc {
-	config := &middlewareConfig{
-		errorHandler: func(w http.ResponseWriter, r *http.Request, res *Result) {
-			// This default is ignored for Echo as we use Echo's own context below
-		},
-	}
+	config := newMiddlewareConfig(opts)
 
-	for _, opt := range opts {
-		opt(config)
+	denied := config.echoDenied
+	if denied == nil && config.errorHandler != nil {
+		denied = func(c echo.Context, res *Result) error {
+			config.errorHandler(c.Response(), c.Request(), res)
+			return nil
+		}
+	}
+	if denied == nil {
+		denied = defaultEchoDenied
 	}
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			key := extractor(c.Request())
-			res, err := limiter.Allow(c.Request().Context(), key)
-
-			if err != nil {
+			c.SetRequest(config.withContext(c.Request(), c.Path()))
+			switch d, res := config.evaluate(c.Request(), c.Response().Header(), limiter, extractor); d {
+			case decisionForbid:
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+			case decisionLimit:
+				return denied(c, res)
+			case decisionCancel:
+				return c.NoContent(http.StatusServiceUnavailable)
+			case decisionError:
+				return c.NoContent(http.StatusInternalServerError)
+			default:
 				return next(c)
 			}
-
-			c.Response().Header().Set("X-RateLimit-Limit", strconv.FormatFloat(res.Limit, 'f', -1, 64))
-			c.Response().Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
-
-			if !res.Allowed {
-				if config.onLimit != nil {
-					config.onLimit(c.Request(), res)
-				}
-
-				c.Response().Header().Set("Retry-After", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
-				return c.JSON(http.StatusTooManyRequests, map[string]string{
-					"error":       "Rate limit exceeded",
-					"retry_after": fmt.Sprintf("%.3fs", res.WaitTime.Seconds()),
-				})
-			}
-
-			return next(c)
 		}
 	}
 }
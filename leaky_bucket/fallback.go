@@ -0,0 +1,110 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// localBucketsSize is the number of in-memory buckets above which idle ones are dropped
+const localBucketsSize = 10000
+
+// WithCallTimeout bounds each call to Redis made by the limiter to d, so that
+// a slow Redis cannot use up the caller's deadline. This includes checks,
+// leases, queue tickets, Pause, Release and Reset, but not the pub/sub
+// connection held by WithWakeups. Calls that time out are answered like any
+// other Redis failure.
+func WithCallTimeout(d time.Duration) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.callTimeout = d
+	}
+}
+
+// WithHedging answers checks from an in-memory bucket with the same rate and
+// burst when Redis has not answered within budget, or fails. The Redis call
+// still completes in the background, so the shared bucket stays accurate.
+// Results decided locally have Source set to SourceLocal.
+//
+// The local bucket only sees this instance's traffic, so while Redis is slow
+// every instance may admit up to the full rate on its own.
+func WithHedging(budget time.Duration) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.hedge = budget
+		lb.local = &localBuckets{tats: make(map[bucketKey]time.Time)}
+	}
+}
+
+// hedged runs the check in Redis and falls back to the local bucket once the budget is exceeded
+func (lb *LeakyBucketRedis) hedged(ctx context.Context, key string, c call, reserved int) (*Result, error) {
+	type reply struct {
+		parts []interface{}
+		err   error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		parts, err := lb.call(context.WithoutCancel(ctx), key, c, reserved)
+		replies <- reply{parts, err}
+	}()
+
+	timer := time.NewTimer(lb.hedge)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case r := <-replies:
+		if r.err == nil {
+			res, perr := parseResult(r.parts, c.rate)
+			if perr == nil {
+				return res, nil
+			}
+			err = perr
+		} else {
+			err = r.err
+		}
+	case <-timer.C:
+		err = context.DeadlineExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return lb.fallback(ctx, key, c, reserved, err)
+}
+
+// localBuckets is an in-memory GCRA used while Redis does not answer
+type localBuckets struct {
+	mu   sync.Mutex
+	tats map[bucketKey]time.Time
+}
+
+// check applies the GCRA of the Redis script to the in-memory bucket for bk
+func (l *localBuckets) check(bk bucketKey, c call, burst, reserved int) *Result {
+	now := time.Now()
+	interval := time.Duration(float64(time.Second) / c.rate)
+	burstOffset := interval * time.Duration(burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tat := l.tats[bk]
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(interval * time.Duration(c.cost))
+	allowAt := newTat.Add(-(burstOffset - interval*time.Duration(reserved)))
+
+	wait := allowAt.Sub(now)
+	if wait > c.maxWait {
+		return &Result{Allowed: false, WaitTime: wait, Remaining: 0, Limit: c.rate, Source: SourceLocal}
+	}
+
+	if _, ok := l.tats[bk]; !ok && len(l.tats) >= localBucketsSize {
+		for k, t := range l.tats {
+			if t.Before(now) {
+				delete(l.tats, k)
+			}
+		}
+	}
+	l.tats[bk] = newTat
+
+	remaining := int(now.Sub(newTat.Add(-burstOffset)) / interval)
+	return &Result{Allowed: true, WaitTime: max(wait, 0), Remaining: max(remaining, 0), Limit: c.rate, Source: SourceLocal}
+}
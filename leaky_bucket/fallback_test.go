@@ -0,0 +1,139 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// slowClient returns a client whose every command takes at least delay, and a direct client to the same server
+func slowClient(t *testing.T, delay time.Duration) (slow, direct redis.UniversalClient) {
+	s := miniredis.RunT(t)
+	slow = redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return latencyConn{Conn: conn, delay: delay}, err
+		},
+	})
+	direct = redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		slow.Close()
+		direct.Close()
+	})
+	return slow, direct
+}
+
+func TestCallTimeout(t *testing.T) {
+	slow, _ := slowClient(t, 200*time.Millisecond)
+
+	lb := New(slow, 10.0, WithCallTimeout(20*time.Millisecond), WithFailurePolicy(FailClosed))
+
+	start := time.Now()
+	res, err := lb.Allow(context.Background(), "call_timeout")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("Expected ErrBackendUnavailable, got %v", err)
+	}
+	if elapsed > 100*time.Millisecond {
+		t.Errorf("Expected the call to be cut off after 20ms, took %v", elapsed)
+	}
+	if res.Allowed || res.Source != SourceFailurePolicy {
+		t.Errorf("Expected a denial from the failure policy, got %+v", res)
+	}
+}
+
+func TestCallTimeout_AllCalls(t *testing.T) {
+	slow, _ := slowClient(t, 200*time.Millisecond)
+
+	leased := New(slow, 10.0, WithCallTimeout(20*time.Millisecond), WithLeasing(5, time.Second))
+	queued := New(slow, 10.0, WithCallTimeout(20*time.Millisecond), WithQueue(0), WithFailurePolicy(FailClosed))
+	ctx := context.Background()
+
+	calls := map[string]func() error{
+		"lease": func() error {
+			_, err := leased.Allow(ctx, "call_timeout_all")
+			return err
+		},
+		"queue": func() error { return queued.Wait(ctx, "call_timeout_all") },
+		"pause": func() error { return queued.Pause(ctx, "call_timeout_all", time.Second) },
+		"reset": func() error { return queued.Reset(ctx, "call_timeout_all") },
+	}
+	for name, call := range calls {
+		start := time.Now()
+		err := call()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: Expected the call to time out, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("%s: Expected the call to be cut off after 20ms, took %v", name, elapsed)
+		}
+	}
+}
+
+func TestHedging(t *testing.T) {
+	slow, direct := slowClient(t, 100*time.Millisecond)
+
+	lb := New(slow, 0.01, WithBurst(2), WithHedging(10*time.Millisecond))
+	ctx := context.Background()
+	key := "hedged"
+
+	start := time.Now()
+	var results []*Result
+	for i := 0; i < 3; i++ {
+		res, err := lb.Allow(ctx, key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		results = append(results, res)
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("Expected hedged checks to answer within the budget, took %v", elapsed)
+	}
+
+	for i, res := range results {
+		if res.Source != SourceLocal {
+			t.Errorf("Check %d: Expected a local decision, got %s", i+1, res.Source)
+		}
+		if want := i < 2; res.Allowed != want {
+			t.Errorf("Check %d: Expected allowed=%v from the local bucket, got %+v", i+1, want, res)
+		}
+	}
+
+	// The Redis calls complete in the background
+	deadline := time.Now().Add(3 * time.Second)
+	for direct.Exists(ctx, key).Val() == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if direct.Exists(ctx, key).Val() != 1 {
+		t.Error("Expected the shared bucket to be updated after the budget was exceeded")
+	}
+}
+
+func TestResult_Source(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+
+	lb := New(client, 0.01, WithDenyCache(10))
+	if res, _ := lb.Allow(ctx, "source"); res.Source != SourceRedis {
+		t.Errorf("Expected %s, got %s", SourceRedis, res.Source)
+	}
+	lb.Allow(ctx, "source")
+	if res, _ := lb.Allow(ctx, "source"); res.Source != SourceDenyCache {
+		t.Errorf("Expected %s, got %s", SourceDenyCache, res.Source)
+	}
+
+	leased := New(client, 0.01, WithBurst(5), WithLeasing(5, time.Minute))
+	leased.Allow(ctx, "source_lease")
+	if res, _ := leased.Allow(ctx, "source_lease"); res.Source != SourceLease {
+		t.Errorf("Expected %s, got %s", SourceLease, res.Source)
+	}
+}
@@ -3,51 +3,58 @@ package leaky_bucket_redis
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
+// GinDeniedHandler writes the response for a rate-limited Gin request.
+// The middleware aborts the context after the handler returns.
+type GinDeniedHandler func(c *gin.Context, res *Result)
+
+// WithGinDeniedHandler sets a Gin-native handler for rate-limited requests.
+// It takes precedence over WithErrorHandler in GinMiddleware and is ignored by the other adapters.
+func WithGinDeniedHandler(h GinDeniedHandler) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.ginDenied = h
+	}
+}
+
+// defaultGinDenied writes the default JSON body for a rate-limited Gin request
+func defaultGinDenied(c *gin.Context, res *Result) {
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Rate limit exceeded",
+		"retry_after": fmt.Sprintf("%.3fs", res.WaitTime.Seconds()),
+	})
+}
+
 // GinMiddleware returns a Gin-compatible middleware
 func GinMiddleware(limiter Limiter, extractor KeyExtractor, opts ...MiddlewareOption) gin.HandlerFunc {
-	config := &middlewareConfig{
-		errorHandler: func(w http.ResponseWriter, r *http.Request, res *Result) {
-			w.Header().Set("Retry-After", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
-			// Default placeholder
-		},
-	}
+	config := newMiddlewareConfig(opts)
 
-	for _, opt := range opts {
-		opt(config)
+	denied := config.ginDenied
+	if denied == nil && config.errorHandler != nil {
+		denied = func(c *gin.Context, res *Result) {
+			config.errorHandler(c.Writer, c.Request, res)
+		}
+	}
+	if denied == nil {
+		denied = defaultGinDenied
 	}
 
 	return func(c *gin.Context) {
-		key := extractor(c.Request)
-		res, err := limiter.Allow(c.Request.Context(), key)
-
-		if err != nil {
+		c.Request = config.withContext(c.Request, c.FullPath())
+		switch d, res := config.evaluate(c.Request, c.Writer.Header(), limiter, extractor); d {
+		case decisionForbid:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		case decisionLimit:
+			denied(c, res)
+			c.Abort()
+		case decisionCancel:
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		case decisionError:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		default:
 			c.Next()
-			return
 		}
-
-		c.Header("X-RateLimit-Limit", strconv.FormatFloat(res.Limit, 'f', -1, 64))
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
-
-		if !res.Allowed {
-			if config.onLimit != nil {
-				config.onLimit(c.Request, res)
-			}
-			
-			// If a custom error handler is provided, use it. 
-			// Otherwise, use a default Gin response.
-			c.Header("Retry-After", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-				"retry_after": fmt.Sprintf("%.3fs", res.WaitTime.Seconds()),
-			})
-			return
-		}
-
-		c.Next()
 	}
 }
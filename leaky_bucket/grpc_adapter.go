@@ -0,0 +1,170 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// GRPCKeyExtractor defines a function to extract a rate limiting key from an incoming gRPC call
+type GRPCKeyExtractor func(ctx context.Context, fullMethod string) string
+
+// ExtractPeerIP returns the IP address of the calling peer as the key
+func ExtractPeerIP(ctx context.Context, fullMethod string) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	ip, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return ip
+}
+
+// ExtractMetadata returns a GRPCKeyExtractor that gets the key from the first
+// value of the named incoming metadata entry
+func ExtractMetadata(name string) GRPCKeyExtractor {
+	return func(ctx context.Context, fullMethod string) string {
+		if vals := metadata.ValueFromIncomingContext(ctx, name); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+}
+
+// GRPCOption configures the gRPC interceptors
+type GRPCOption func(*grpcConfig)
+
+type grpcConfig struct {
+	perMessage bool
+	onLimit    func(ctx context.Context, fullMethod string, res *Result)
+}
+
+// WithPerMessage makes StreamServerInterceptor check the limiter for every
+// message received from the client, in addition to the stream itself.
+// A limited message ends the stream with codes.ResourceExhausted.
+func WithPerMessage() GRPCOption {
+	return func(c *grpcConfig) {
+		c.perMessage = true
+	}
+}
+
+// WithGRPCOnLimit sets a callback that is triggered whenever a call or message is rate limited
+func WithGRPCOnLimit(cb func(ctx context.Context, fullMethod string, res *Result)) GRPCOption {
+	return func(c *grpcConfig) {
+		c.onLimit = cb
+	}
+}
+
+func newGRPCConfig(opts []GRPCOption) *grpcConfig {
+	config := &grpcConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// check returns a ResourceExhausted status error when the call is limited.
+// Like the HTTP middlewares it fails open on limiter errors other than
+// configuration errors, which fail the call with codes.Internal.
+func (c *grpcConfig) check(ctx context.Context, limiter Limiter, key, fullMethod string) (*Result, error) {
+	res, err := limiter.Allow(ctx, key)
+	if res == nil {
+		if failsOpen(err) {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if res.Allowed {
+		return res, nil
+	}
+	if c.onLimit != nil {
+		c.onLimit(ctx, fullMethod, res)
+	}
+	return res, limitedStatus(res).Err()
+}
+
+// limitedStatus builds the ResourceExhausted status with RetryInfo details for res
+func limitedStatus(res *Result) *status.Status {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(res.WaitTime)}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// rateLimitMetadata mirrors the X-RateLimit-* HTTP headers as response metadata
+func rateLimitMetadata(res *Result) metadata.MD {
+	return metadata.Pairs(
+		"x-ratelimit-limit", strconv.FormatFloat(res.Limit, 'f', -1, 64),
+		"x-ratelimit-remaining", strconv.Itoa(res.Remaining),
+	)
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor that rate limits unary calls.
+// Limited calls fail with codes.ResourceExhausted and an errdetails.RetryInfo
+// detail carrying the wait time.
+func UnaryServerInterceptor(limiter Limiter, extractor GRPCKeyExtractor, opts ...GRPCOption) grpc.UnaryServerInterceptor {
+	config := newGRPCConfig(opts)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		res, err := config.check(ctx, limiter, extractor(ctx, info.FullMethod), info.FullMethod)
+		if res != nil {
+			grpc.SetHeader(ctx, rateLimitMetadata(res))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC interceptor that rate limits the
+// opening of streams and, with WithPerMessage, every received message.
+func StreamServerInterceptor(limiter Limiter, extractor GRPCKeyExtractor, opts ...GRPCOption) grpc.StreamServerInterceptor {
+	config := newGRPCConfig(opts)
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		key := extractor(ctx, info.FullMethod)
+
+		res, err := config.check(ctx, limiter, key, info.FullMethod)
+		if res != nil {
+			ss.SetHeader(rateLimitMetadata(res))
+		}
+		if err != nil {
+			return err
+		}
+
+		if config.perMessage {
+			ss = &limitedServerStream{ServerStream: ss, config: config, limiter: limiter, key: key, method: info.FullMethod}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// limitedServerStream checks the limiter for every message received from the client
+type limitedServerStream struct {
+	grpc.ServerStream
+	config  *grpcConfig
+	limiter Limiter
+	key     string
+	method  string
+}
+
+func (s *limitedServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	_, err := s.config.check(s.Context(), s.limiter, s.key, s.method)
+	return err
+}
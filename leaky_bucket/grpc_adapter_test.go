@@ -0,0 +1,208 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testServiceDesc describes a hand-written service with a unary Ping and a bidirectional Chat method
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "leakybucket.test.Service",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Ping",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(emptypb.Empty)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) { return &emptypb.Empty{}, nil }
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/leakybucket.test.Service/Ping"}, handler)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Chat",
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			for {
+				in := new(emptypb.Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				if err := stream.SendMsg(in); err != nil {
+					return err
+				}
+			}
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+// startGRPC serves testServiceDesc over an in-process bufconn listener and returns a client connection
+func startGRPC(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&testServiceDesc, struct{}{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func ping(ctx context.Context, conn *grpc.ClientConn, opts ...grpc.CallOption) error {
+	return conn.Invoke(ctx, "/leakybucket.test.Service/Ping", &emptypb.Empty{}, &emptypb.Empty{}, opts...)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var limited string
+	lb := New(client, 10.0)
+	conn := startGRPC(t, grpc.UnaryInterceptor(UnaryServerInterceptor(lb, ExtractMetadata("x-api-key"),
+		WithGRPCOnLimit(func(ctx context.Context, fullMethod string, res *Result) {
+			limited = fullMethod
+		}))))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "grpc_unary")
+
+	var header metadata.MD
+	if err := ping(ctx, conn, grpc.Header(&header)); err != nil {
+		t.Fatalf("Expected first call to succeed, got %v", err)
+	}
+	if got := header.Get("x-ratelimit-limit"); len(got) != 1 || got[0] != "10" {
+		t.Errorf("Expected x-ratelimit-limit metadata 10, got %v", got)
+	}
+
+	err := ping(ctx, conn)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted, got %v", err)
+	}
+
+	var retry *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			retry = ri
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Errorf("Expected RetryInfo with positive delay, got %v", st.Details())
+	}
+	if limited != "/leakybucket.test.Service/Ping" {
+		t.Errorf("Expected onLimit callback for Ping, got %q", limited)
+	}
+
+	// Another key has its own bucket
+	other := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "grpc_other")
+	if err := ping(other, conn); err != nil {
+		t.Errorf("Expected call with another key to succeed, got %v", err)
+	}
+}
+
+func TestUnaryServerInterceptor_FailOpen(t *testing.T) {
+	client := createTestClient(t)
+	client.Close()
+
+	conn := startGRPC(t, grpc.UnaryInterceptor(UnaryServerInterceptor(New(client, 10.0), ExtractPeerIP)))
+	for i := 0; i < 3; i++ {
+		if err := ping(context.Background(), conn); err != nil {
+			t.Errorf("Expected fail open, got %v", err)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor_ConfigError(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	conn := startGRPC(t, grpc.UnaryInterceptor(UnaryServerInterceptor(New(client, 0), ExtractPeerIP)))
+	if err := ping(context.Background(), conn); status.Code(err) != codes.Internal {
+		t.Errorf("Expected Internal, got %v", err)
+	}
+}
+
+func openChat(t *testing.T, conn *grpc.ClientConn) grpc.ClientStream {
+	t.Helper()
+	stream, err := conn.NewStream(context.Background(), &testServiceDesc.Streams[0], "/leakybucket.test.Service/Chat")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	return stream
+}
+
+// exchange sends one message on stream and waits for its echo
+func exchange(stream grpc.ClientStream) error {
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return stream.RecvMsg(&emptypb.Empty{})
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	conn := startGRPC(t, grpc.StreamInterceptor(StreamServerInterceptor(New(client, 10.0), ExtractPeerIP)))
+
+	// Without per-message limiting only opening the stream counts
+	first := openChat(t, conn)
+	for i := 0; i < 3; i++ {
+		if err := exchange(first); err != nil {
+			t.Fatalf("Message %d: Expected echo, got %v", i+1, err)
+		}
+	}
+	first.CloseSend()
+
+	second := openChat(t, conn)
+	if err := exchange(second); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected second stream to be limited, got %v", err)
+	}
+}
+
+func TestStreamServerInterceptor_PerMessage(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	// Opening the stream uses one of the three permits
+	lb := New(client, 0.01, WithBurst(3))
+	conn := startGRPC(t, grpc.StreamInterceptor(StreamServerInterceptor(lb, ExtractPeerIP, WithPerMessage())))
+
+	stream := openChat(t, conn)
+	for i := 0; i < 2; i++ {
+		if err := exchange(stream); err != nil {
+			t.Fatalf("Message %d: Expected echo, got %v", i+1, err)
+		}
+	}
+	if err := exchange(stream); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected third message to be limited, got %v", err)
+	}
+}
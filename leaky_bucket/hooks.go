@@ -0,0 +1,49 @@
+package leaky_bucket_redis
+
+import "context"
+
+// Hooks are called on the events of a limiter. Any of them may be nil. They
+// run synchronously on the calling goroutine, so they should return quickly.
+type Hooks struct {
+	OnAllow        func(ctx context.Context, key string, res *Result) // OnAllow is called for every allowed request.
+	OnDeny         func(ctx context.Context, key string, res *Result) // OnDeny is called for every denied request.
+	OnBackendError func(ctx context.Context, key string, err error)   // OnBackendError is called when a call to Redis fails or times out.
+	OnFallback     func(ctx context.Context, key string, res *Result) // OnFallback is called when the failure policy or the local bucket decides instead of Redis.
+}
+
+// WithHooks registers hooks on the limiter. It may be given several times;
+// the hooks are called in the order they were registered.
+//
+// OnAllow and OnDeny see the decisions of Allow, Reserve and AllowN,
+// including the checks made by Wait outside of queue mode.
+func WithHooks(hooks Hooks) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.hooks = append(lb.hooks, hooks)
+	}
+}
+
+// decided calls the OnAllow or OnDeny hooks for res, if any
+func (lb *LeakyBucketRedis) decided(ctx context.Context, key string, res *Result) {
+	if res == nil {
+		return
+	}
+	for _, h := range lb.hooks {
+		if res.Allowed && h.OnAllow != nil {
+			h.OnAllow(ctx, key, res)
+		} else if !res.Allowed && h.OnDeny != nil {
+			h.OnDeny(ctx, key, res)
+		}
+	}
+}
+
+// fellBack calls the OnBackendError and OnFallback hooks
+func (lb *LeakyBucketRedis) fellBack(ctx context.Context, key string, err error, res *Result) {
+	for _, h := range lb.hooks {
+		if h.OnBackendError != nil {
+			h.OnBackendError(ctx, key, err)
+		}
+		if h.OnFallback != nil {
+			h.OnFallback(ctx, key, res)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithHooks(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var allowed, denied, second int
+	lb := New(client, 1.0,
+		WithHooks(Hooks{
+			OnAllow: func(ctx context.Context, key string, res *Result) { allowed++ },
+			OnDeny:  func(ctx context.Context, key string, res *Result) { denied++ },
+		}),
+		WithHooks(Hooks{
+			OnDeny: func(ctx context.Context, key string, res *Result) { second++ },
+		}),
+	)
+	ctx := context.Background()
+
+	lb.Allow(ctx, "hooks")
+	lb.Allow(ctx, "hooks")
+
+	if allowed != 1 || denied != 1 {
+		t.Errorf("Expected 1 allowed and 1 denied event, got %d and %d", allowed, denied)
+	}
+	if second != 1 {
+		t.Errorf("Expected the second hooks to see 1 denial, got %d", second)
+	}
+}
+
+func TestWithHooks_BackendError(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	var (
+		backendErr error
+		fallback   *Result
+		allowed    int
+	)
+	lb := New(client, 1.0, WithHooks(Hooks{
+		OnAllow:        func(ctx context.Context, key string, res *Result) { allowed++ },
+		OnBackendError: func(ctx context.Context, key string, err error) { backendErr = err },
+		OnFallback:     func(ctx context.Context, key string, res *Result) { fallback = res },
+	}))
+
+	lb.Allow(context.Background(), "hooks_fail")
+
+	if !errors.Is(backendErr, ErrBackendUnavailable) {
+		t.Errorf("Expected OnBackendError with ErrBackendUnavailable, got %v", backendErr)
+	}
+	if fallback == nil || fallback.Source != SourceFailurePolicy {
+		t.Errorf("Expected OnFallback with the failure policy decision, got %+v", fallback)
+	}
+	if allowed != 1 {
+		t.Errorf("Expected the fail open decision to be reported as allowed, got %d", allowed)
+	}
+}
+
+func TestWithHooks_Leasing(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var allowed int
+	lb := New(client, 1.0, WithBurst(5), WithLeasing(5, time.Minute), WithHooks(Hooks{
+		OnAllow: func(ctx context.Context, key string, res *Result) { allowed++ },
+	}))
+	for i := 0; i < 3; i++ {
+		lb.Allow(context.Background(), "hooks_lease")
+	}
+
+	if allowed != 3 {
+		t.Errorf("Expected 3 allowed events for leased permits, got %d", allowed)
+	}
+}
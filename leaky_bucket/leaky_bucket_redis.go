@@ -3,7 +3,10 @@ package leaky_bucket_redis
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,21 +17,95 @@ var (
 	ErrInvalidRate = errors.New("rate must be greater than 0")
 	// ErrInvalidKey is returned when key is empty
 	ErrInvalidKey = errors.New("key cannot be empty")
+	// ErrCostExceedsBurst is returned when a weighted request needs more permits than the burst holds
+	ErrCostExceedsBurst = errors.New("cost exceeds burst")
+	// ErrRateLimited is returned when a request is rejected by a client-side limiter such as Transport
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrBackendUnavailable wraps the error of a call to Redis that failed or
+	// timed out. It comes with the Result decided by the failure policy.
+	ErrBackendUnavailable = errors.New("rate limit backend unavailable")
+	// ErrScriptReply is returned when Redis answers a rate limit script with a reply of unexpected shape
+	ErrScriptReply = errors.New("unexpected rate limit script reply")
 )
 
+// ErrorClass returns a short, stable name for the kind of err, suitable as a
+// metric label or log attribute: "backend_unavailable", "script_reply",
+// "invalid_key", "config", "queue_full", "cancelled" or "other"
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrBackendUnavailable):
+		return "backend_unavailable"
+	case errors.Is(err, ErrScriptReply):
+		return "script_reply"
+	case errors.Is(err, ErrInvalidKey):
+		return "invalid_key"
+	case errors.Is(err, ErrInvalidRate), errors.Is(err, ErrCostExceedsBurst):
+		return "config"
+	case errors.Is(err, ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "cancelled"
+	}
+	return "other"
+}
+
+// errBreakerOpen is the cause of ErrBackendUnavailable while the circuit breaker is open
+var errBreakerOpen = errors.New("circuit breaker is open")
+
 // Result represents the state of a rate limit check.
 // It contains all the metadata needed to decide whether to allow a request
 // and how long to wait if rate limited.
 type Result struct {
 	Allowed   bool          // Allowed is true if the request should be permitted.
-	WaitTime  time.Duration // WaitTime is the duration to wait before the next allowed request.
+	WaitTime  time.Duration // WaitTime is the duration to wait before the next allowed request, or before acting on a reserved one.
 	Remaining int           // Remaining is the approximate number of requests left in the current burst window.
 	Limit     float64       // Limit is the configured requests per second.
+	Source    Source        // Source tells whether the decision came from Redis or from a local fallback.
+}
+
+// Source is where a rate limit decision came from
+type Source int
+
+const (
+	// SourceRedis means the decision was made by the Redis bucket.
+	SourceRedis Source = iota
+	// SourceLease means the request used a permit leased from Redis, see WithLeasing.
+	SourceLease
+	// SourceDenyCache means the request was denied from the cache of denied keys, see WithDenyCache.
+	SourceDenyCache
+	// SourceFailurePolicy means Redis could not be reached and the failure policy applied, see WithFailurePolicy.
+	SourceFailurePolicy
+	// SourceLocal means Redis did not answer in time and an in-memory bucket decided, see WithHedging.
+	SourceLocal
+)
+
+// String returns the name of the source
+func (s Source) String() string {
+	switch s {
+	case SourceRedis:
+		return "redis"
+	case SourceLease:
+		return "lease"
+	case SourceDenyCache:
+		return "deny-cache"
+	case SourceFailurePolicy:
+		return "failure-policy"
+	case SourceLocal:
+		return "local"
+	}
+	return "unknown"
 }
 
 // Limiter defines the interface for distributed rate limiting.
 type Limiter interface {
 	// Allow checks if a request for the given key is permitted.
+	//
+	// A non-nil Result may come together with an error: it is then the
+	// decision to apply anyway, e.g. the failure policy while the backend is
+	// unavailable, and callers log the error but act on the Result. A nil
+	// Result means no decision could be made. Custom limiters that cannot
+	// reach their backend either return nil, or a Result with the Source
+	// SourceFailurePolicy and an error wrapping ErrBackendUnavailable.
 	Allow(ctx context.Context, key string) (*Result, error)
 	// Wait blocks until a request for the given key is permitted or the context is cancelled.
 	Wait(ctx context.Context, key string) error
@@ -36,11 +113,91 @@ type Limiter interface {
 	WaitTimeout(ctx context.Context, key string, timeout time.Duration) error
 }
 
+// Reserver is implemented by limiters that can hold a slot for a request
+// that is willing to wait for it, such as LeakyBucketRedis.
+type Reserver interface {
+	// Reserve checks a request for the given key, reserving a slot if it can proceed within maxWait.
+	// It returns Results and errors like Limiter.Allow.
+	Reserve(ctx context.Context, key string, maxWait time.Duration) (*Result, error)
+}
+
+// Releaser is implemented by limiters that can take back permits reserved
+// for a request that gave up before they were due, such as LeakyBucketRedis.
+type Releaser interface {
+	// Release hands n unused permits for the given key back to the bucket.
+	Release(ctx context.Context, key string, n int) error
+}
+
+// Pauser is implemented by limiters whose buckets can be paused for every
+// instance sharing them, such as LeakyBucketRedis.
+type Pauser interface {
+	// Pause rejects all requests for the given key until d has elapsed.
+	Pause(ctx context.Context, key string, d time.Duration) error
+}
+
+// WeightedLimiter is implemented by limiters whose requests can consume
+// several permits at once, such as LeakyBucketRedis.
+type WeightedLimiter interface {
+	// AllowN checks if a request costing n permits for the given key is permitted.
+	AllowN(ctx context.Context, key string, n int) (*Result, error)
+	// WaitN blocks until a request costing n permits for the given key is permitted or the context is cancelled.
+	WaitN(ctx context.Context, key string, n int) error
+}
+
+// Unwrapper is implemented by limiters that decorate another limiter, such as
+// the wrappers of the metrics and telemetry packages.
+type Unwrapper interface {
+	// Unwrap returns the wrapped limiter.
+	Unwrap() Limiter
+}
+
+// As returns the first limiter implementing T among limiter and the limiters
+// it wraps, like errors.As does for errors. The adapters use it to find
+// optional interfaces such as Reserver or Pauser behind decorators.
+func As[T any](limiter Limiter) (T, bool) {
+	for limiter != nil {
+		if t, ok := limiter.(T); ok {
+			return t, true
+		}
+		u, ok := limiter.(Unwrapper)
+		if !ok {
+			break
+		}
+		limiter = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
 // LeakyBucketRedis implements distributed rate limiting using Redis and the GCRA algorithm.
 type LeakyBucketRedis struct {
 	client redis.UniversalClient
 	rate   float64 // Requests per second
 	burst  int     // Maximum bucket capacity
+
+	reserves map[Priority]float64 // Burst fraction each priority must leave untouched
+	wakeups  bool                 // Wait sleeps on pub/sub notifications instead of reserving
+	queued   bool                 // Wait takes a ticket in a queue shared by all instances
+	maxQueue int                  // Maximum number of queued waiters per key, 0 for no limit
+
+	leaseBatch int                  // Permits claimed at once by Allow when leasing
+	leaseTTL   time.Duration        // How long claimed permits may be served locally
+	leaseMu    sync.Mutex           // Guards leases
+	leases     map[bucketKey]*lease // Permits claimed per key, nil unless leasing
+
+	batcher *batcher   // Collects concurrent checks into pipelines, nil unless batching
+	denials *denyCache // Remembers denied keys, nil unless caching denials
+
+	failurePolicy FailurePolicy // Decision when Redis cannot be reached
+	breaker       *breaker      // Short-circuits calls while Redis is unhealthy, nil unless enabled
+
+	callTimeout time.Duration // Bound of each script call, 0 for none
+	hedge       time.Duration // Budget after which the local bucket decides, 0 unless hedging
+	local       *localBuckets // In-memory buckets used when hedging
+
+	hooks []Hooks // Event hooks, see WithHooks
+
+	err error // Configuration error returned by every call, see New
 }
 
 // Option configures the LeakyBucketRedis
@@ -56,12 +213,24 @@ func WithBurst(burst int) Option {
 	}
 }
 
-// New creates a new LeakyBucketRedis instance
+// WithWakeups makes Wait check the bucket without reserving a slot, and wake
+// up early when capacity is returned by a cancelled wait or Reset on any
+// instance. Waiters are no longer served in arrival order, and each holds a
+// pub/sub connection while it waits.
+func WithWakeups() Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.wakeups = true
+	}
+}
+
+// New creates a new LeakyBucketRedis instance.
+// If rate is not a positive number, every call returns ErrInvalidRate.
 func New(client redis.UniversalClient, rate float64, opts ...Option) *LeakyBucketRedis {
 	lb := &LeakyBucketRedis{
 		client: client,
 		rate:   rate,
 		burst:  1,
+		err:    validateRate(rate),
 	}
 
 	for _, opt := range opts {
@@ -71,40 +240,84 @@ func New(client redis.UniversalClient, rate float64, opts ...Option) *LeakyBucke
 	return lb
 }
 
+// validateRate returns ErrInvalidRate unless rate is a positive, finite number
+func validateRate(rate float64) error {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return ErrInvalidRate
+	}
+	return nil
+}
+
 // NewLeakyBucket creates a new LeakyBucketRedis instance for backward compatibility
 func NewLeakyBucket(client *redis.Client, key string, rate float64) *LeakyBucketRedis {
 	// Note: The new design prefers passing the key to Allow()
-	// This wrapper allows the old usage by storing a default key if needed, 
-	// but here we just return the new struct. 
-	// To maintain full compatibility with the old Allow() signature, 
+	// This wrapper allows the old usage by storing a default key if needed,
+	// but here we just return the new struct.
+	// To maintain full compatibility with the old Allow() signature,
 	// we'd need to store the key in the struct.
 	return &LeakyBucketRedis{
 		client: client,
 		rate:   rate,
 		burst:  1,
+		err:    validateRate(rate),
 	}
 }
 
 // Allow checks if a request should be allowed based on the rate limit.
 // If the key is empty, it returns an error.
+//
+// If Redis cannot be reached, Allow returns an error wrapping
+// ErrBackendUnavailable together with the Result of the failure policy.
 func (lb *LeakyBucketRedis) Allow(ctx context.Context, key string) (*Result, error) {
-	if key == "" {
-		return nil, ErrInvalidKey
+	if lb.leases != nil {
+		res, err := lb.allowLeased(ctx, key)
+		lb.decided(ctx, key, res)
+		return res, err
 	}
+	return lb.Reserve(ctx, key, 0)
+}
 
-	now := time.Now()
-	nowFloat := float64(now.UnixNano()) / 1e9
+// Reserve is like Allow, but when the request could proceed within maxWait it
+// reserves the slot for the caller instead of denying it. The returned Result
+// is then Allowed with a WaitTime the caller must wait before acting.
+// Requests that would have to wait longer than maxWait are denied without
+// consuming capacity. Reserve with a maxWait of 0 is equivalent to Allow.
+//
+// The priority attached with ContextWithPriority decides how much of the
+// burst the request may use, see WithPriorityReserve.
+func (lb *LeakyBucketRedis) Reserve(ctx context.Context, key string, maxWait time.Duration) (*Result, error) {
+	return lb.eval(ctx, key, call{rate: lb.rate, maxWait: maxWait, cost: 1})
+}
 
-	// GCRA Implementation in Lua
-	// ARGV[1]: rate (requests per second)
-	// ARGV[2]: burst (capacity)
-	// ARGV[3]: now (current time in seconds)
-	script := `
-		local key = KEYS[1]
-		local rate = tonumber(ARGV[1])
-		local burst = tonumber(ARGV[2])
-		local now = tonumber(ARGV[3])
+// AllowN is like Allow for a request that consumes n permits at once,
+// e.g. n bytes when the rate is expressed in bytes per second.
+// It returns ErrCostExceedsBurst if n is larger than the burst.
+func (lb *LeakyBucketRedis) AllowN(ctx context.Context, key string, n int) (*Result, error) {
+	return lb.eval(ctx, key, call{rate: lb.rate, cost: n})
+}
 
+// WaitN is like Wait for a request that consumes n permits at once
+func (lb *LeakyBucketRedis) WaitN(ctx context.Context, key string, n int) error {
+	if lb.queued {
+		return lb.waitQueued(ctx, key, n)
+	}
+	if lb.wakeups {
+		return lb.waitWoken(ctx, key, n)
+	}
+	return lb.waitReserved(ctx, key, n)
+}
+
+// call holds the per-call parameters of the GCRA script
+type call struct {
+	rate    float64       // requests per second, which adaptive limiters vary per key
+	maxWait time.Duration // longest wait that still reserves a slot
+	cost    int           // permits consumed by the request
+}
+
+// gcraScript is the GCRA implementation in Lua, shared by the scripts that
+// check a bucket. gcra returns {allowed, wait, remaining}.
+const gcraScript = `
+	local function gcra(key, rate, burst, now, max_wait, reserved, cost)
 		local emission_interval = 1.0 / rate
 		local burst_offset = emission_interval * burst
 
@@ -115,62 +328,431 @@ func (lb *LeakyBucketRedis) Allow(ctx context.Context, key string) (*Result, err
 			tat = tonumber(tat)
 		end
 
-		local new_tat = math.max(tat, now) + emission_interval
-		local allow_at = new_tat - burst_offset
+		local new_tat = math.max(tat, now) + emission_interval * cost
+		local allow_at = new_tat - (burst_offset - emission_interval * reserved)
 
+		-- Allow 1µs of slack for the rounding of stored TATs
 		local wait = allow_at - now
-		if wait > 0 then
+		if wait > max_wait + 1e-6 then
 			return {0, tostring(wait), "0"}
 		end
 
-		redis.call('SET', key, new_tat, 'EX', math.ceil(burst_offset + emission_interval))
-		
-		local remaining = math.floor((now - (new_tat - burst_offset)) / emission_interval)
-		return {1, "0", tostring(remaining)}
-	`
+		-- Format explicitly: Lua's default of 14 significant digits loses sub-millisecond precision
+		redis.call('SET', key, string.format('%.6f', new_tat), 'EX', math.ceil(math.max(new_tat - now, burst_offset) + emission_interval))
+
+		local remaining = math.max(0, math.floor((now - (new_tat - burst_offset) + 1e-6) / emission_interval))
+		-- The slack is not part of the wait: an allowed request never waits beyond max_wait
+		return {1, tostring(math.min(math.max(wait, 0), max_wait)), tostring(remaining)}
+	end
+`
+
+// gcraCall checks a single bucket. It is loaded once and then run by its SHA.
+// ARGV[1]: rate (requests per second)
+// ARGV[2]: burst (capacity)
+// ARGV[3]: now (current time in seconds)
+// ARGV[4]: max_wait (longest wait in seconds that still reserves a slot)
+// ARGV[5]: reserved (requests that must remain in the bucket for this priority)
+// ARGV[6]: cost (permits consumed by this request)
+var gcraCall = redis.NewScript(gcraScript + `
+	return gcra(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6]))
+`)
+
+// eval runs the GCRA script for key and reports the decision to the hooks
+func (lb *LeakyBucketRedis) eval(ctx context.Context, key string, c call) (*Result, error) {
+	res, err := lb.decide(ctx, key, c)
+	lb.decided(ctx, key, res)
+	return res, err
+}
+
+// decide runs the GCRA script for key, unless the denial is cached
+func (lb *LeakyBucketRedis) decide(ctx context.Context, key string, c call) (*Result, error) {
+	if lb.err != nil {
+		return nil, lb.err
+	}
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	if err := validateRate(c.rate); err != nil {
+		return nil, err
+	}
+	if c.cost < 1 {
+		c.cost = 1
+	}
+	if c.cost > lb.burst {
+		return nil, ErrCostExceedsBurst
+	}
+
+	bk := bucketKey{key: key, priority: PriorityFromContext(ctx)}
+	if lb.denials != nil {
+		if res, ok := lb.denials.check(bk, c.maxWait, c.rate); ok {
+			return res, nil
+		}
+	}
+
+	res, err := lb.check(ctx, key, c, lb.reserved(bk.priority))
+	if err == nil && lb.denials != nil && c.cost == 1 {
+		lb.denials.remember(bk, res)
+	}
+	return res, err
+}
 
-	res, err := lb.client.Eval(ctx, script, []string{key}, lb.rate, lb.burst, nowFloat).Result()
+// check runs the GCRA script for key and applies the fallbacks when Redis does not answer
+func (lb *LeakyBucketRedis) check(ctx context.Context, key string, c call, reserved int) (*Result, error) {
+	if lb.hedge > 0 {
+		return lb.hedged(ctx, key, c, reserved)
+	}
+
+	reply, err := lb.call(ctx, key, c, reserved)
 	if err != nil {
-		// Fail open on Redis error
-		return &Result{Allowed: true, WaitTime: 0, Remaining: lb.burst, Limit: lb.rate}, nil
+		return lb.fallback(ctx, key, c, reserved, err)
+	}
+	return parseResult(reply, c.rate)
+}
+
+// call runs the GCRA script for key through redisCall
+func (lb *LeakyBucketRedis) call(ctx context.Context, key string, c call, reserved int) ([]interface{}, error) {
+	return redisCall(ctx, lb, func(ctx context.Context) ([]interface{}, error) {
+		return lb.run(ctx, key, c, reserved)
+	})
+}
+
+// redisCall runs fn, a call to Redis, within the call timeout and records its
+// outcome in the circuit breaker. While the breaker is open it fails with
+// errBreakerOpen without calling fn. Every script of the limiter goes through it.
+func redisCall[T any](ctx context.Context, lb *LeakyBucketRedis, fn func(context.Context) (T, error)) (T, error) {
+	if lb.breaker != nil && !lb.breaker.ready() {
+		var zero T
+		return zero, errBreakerOpen
+	}
+
+	start := time.Now()
+	v, err := within(ctx, lb.callTimeout, fn)
+
+	// Calls abandoned by the caller say nothing about Redis, and nil replies are answers
+	if lb.breaker != nil && ctx.Err() == nil {
+		failure := err
+		if errors.Is(err, redis.Nil) {
+			failure = nil
+		}
+		lb.breaker.record(failure, time.Since(start))
+	}
+	return v, err
+}
+
+// within runs fn but stops waiting for it after timeout, if positive. Clients
+// only honor context deadlines on the socket when configured to, so the call
+// is left to finish on its own.
+func within[T any](ctx context.Context, timeout time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type reply struct {
+		v   T
+		err error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		v, err := fn(ctx)
+		replies <- reply{v, err}
+	}()
+
+	select {
+	case r := <-replies:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// run runs the GCRA script for key, batched if enabled
+func (lb *LeakyBucketRedis) run(ctx context.Context, key string, c call, reserved int) ([]interface{}, error) {
+	if lb.batcher != nil {
+		return lb.batcher.do(ctx, key, c, reserved)
 	}
+	return gcraCall.Run(ctx, lb.client, []string{key}, lb.gcraArgs(c, reserved)...).Slice()
+}
 
-	parts := res.([]interface{})
-	allowed := parts[0].(int64) == 1
-	waitSecs, _ := strconv.ParseFloat(parts[1].(string), 64)
-	remaining, _ := strconv.Atoi(parts[2].(string))
+// gcraArgs returns the arguments of gcraCall
+func (lb *LeakyBucketRedis) gcraArgs(c call, reserved int) []interface{} {
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+	return []interface{}{c.rate, lb.burst, nowFloat, c.maxWait.Seconds(), reserved, c.cost}
+}
+
+// fallback returns the Result used when a call to Redis failed with err: the
+// local bucket when hedging, or else the failure policy along with the error
+func (lb *LeakyBucketRedis) fallback(ctx context.Context, key string, c call, reserved int, err error) (*Result, error) {
+	if !errors.Is(err, ErrScriptReply) {
+		err = fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+	}
+	if lb.local != nil {
+		res := lb.local.check(bucketKey{key: key, priority: PriorityFromContext(ctx)}, c, lb.burst, reserved)
+		lb.fellBack(ctx, key, err, res)
+		return res, nil
+	}
+	res := lb.failure(c.rate)
+	lb.fellBack(ctx, key, err, res)
+	return res, err
+}
+
+// failure returns the Result used when Redis cannot be reached, according to the failure policy
+func (lb *LeakyBucketRedis) failure(rate float64) *Result {
+	if lb.failurePolicy == FailClosed {
+		return &Result{Allowed: false, WaitTime: time.Duration(float64(time.Second) / rate), Remaining: 0, Limit: rate, Source: SourceFailurePolicy}
+	}
+	return &Result{Allowed: true, WaitTime: 0, Remaining: lb.burst, Limit: rate, Source: SourceFailurePolicy}
+}
+
+// parseResult converts the {allowed, wait, remaining} reply of gcra, or
+// returns ErrScriptReply if it has another shape
+func parseResult(parts []interface{}, rate float64) (*Result, error) {
+	allowed, waitSecs, remaining, err := parseReply(parts)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Result{
-		Allowed:   allowed,
+		Allowed:   allowed == 1,
 		WaitTime:  time.Duration(waitSecs * float64(time.Second)),
 		Remaining: remaining,
-		Limit:     lb.rate,
+		Limit:     rate,
 	}, nil
 }
 
+// parseReply checks and converts the {int, "float", "int"} replies of the scripts
+func parseReply(parts []interface{}) (n int64, waitSecs float64, remaining int, err error) {
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("%w: %v", ErrScriptReply, parts)
+	}
+	n, ok := parts[0].(int64)
+	wait, okWait := parts[1].(string)
+	rem, okRem := parts[2].(string)
+	if !ok || !okWait || !okRem {
+		return 0, 0, 0, fmt.Errorf("%w: %v", ErrScriptReply, parts)
+	}
+
+	waitSecs, errWait := strconv.ParseFloat(wait, 64)
+	remaining, errRem := strconv.Atoi(rem)
+	if errWait != nil || errRem != nil || math.IsNaN(waitSecs) {
+		return 0, 0, 0, fmt.Errorf("%w: %v", ErrScriptReply, parts)
+	}
+	return n, waitSecs, remaining, nil
+}
+
+// Pause blocks the bucket for key so that no request is allowed before d has
+// elapsed, on any instance sharing the bucket. It is meant for honoring
+// upstream back-off signals such as Retry-After. A pause never shortens an
+// existing backlog.
+func (lb *LeakyBucketRedis) Pause(ctx context.Context, key string, d time.Duration) error {
+	if lb.err != nil {
+		return lb.err
+	}
+	if key == "" {
+		return ErrInvalidKey
+	}
+
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+
+	// Moves the TAT far enough ahead that the next request is allowed at now + pause.
+	// ARGV[1]: rate, ARGV[2]: burst, ARGV[3]: now, ARGV[4]: pause (seconds)
+	script := `
+		local key = KEYS[1]
+		local rate = tonumber(ARGV[1])
+		local burst = tonumber(ARGV[2])
+		local now = tonumber(ARGV[3])
+		local pause = tonumber(ARGV[4])
+
+		local emission_interval = 1.0 / rate
+		local burst_offset = emission_interval * burst
+
+		local tat = tonumber(redis.call('GET', key) or now)
+		local paused_tat = math.max(tat, now + pause + burst_offset - emission_interval)
+
+		redis.call('SET', key, string.format('%.6f', paused_tat), 'EX', math.ceil(paused_tat - now + emission_interval))
+		return 1
+	`
+
+	_, err := redisCall(ctx, lb, func(ctx context.Context) (interface{}, error) {
+		return lb.client.Eval(ctx, script, []string{key}, lb.rate, lb.burst, nowFloat, d.Seconds()).Result()
+	})
+	return err
+}
+
 // Wait blocks until the request is allowed or the context is cancelled.
-// It continuously calls Allow and waits for the calculated WaitTime if not allowed,
-// or until the provided context is done.
+// It reserves a slot with a single call to Redis and sleeps until the slot
+// is due, so concurrent waiters on any instance are served in arrival order.
+// If the context ends first, the slot is handed back to the bucket.
+// If Redis cannot be reached, Wait returns at once: with nil under FailOpen,
+// or with an error wrapping ErrBackendUnavailable under FailClosed.
 func (lb *LeakyBucketRedis) Wait(ctx context.Context, key string) error {
+	return lb.WaitN(ctx, key, 1)
+}
+
+// waitReserved reserves n permits once and sleeps until they are due
+func (lb *LeakyBucketRedis) waitReserved(ctx context.Context, key string, n int) error {
+	return lb.waitCall(ctx, key, call{rate: lb.rate, cost: n})
+}
+
+// waitCall reserves the permits of c once, waiting at most until the deadline
+// of ctx, and sleeps until they are due. If ctx ends first they are released.
+func (lb *LeakyBucketRedis) waitCall(ctx context.Context, key string, c call) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.maxWait = maxWaitFor(ctx)
+	res, err := lb.eval(ctx, key, c)
+	if failedClosed(res, err) {
+		return err
+	}
+	if !res.Allowed {
+		// The slot would only be due after the deadline
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if res.WaitTime > 0 && !sleepContext(ctx, res.WaitTime) {
+		if res.Source == SourceRedis {
+			lb.release(context.WithoutCancel(ctx), key, c.rate, c.cost)
+		}
+		return ctx.Err()
+	}
+	return nil
+}
+
+// waitWoken checks the bucket until n permits are granted, sleeping until
+// they should be available or until capacity is returned to the bucket
+func (lb *LeakyBucketRedis) waitWoken(ctx context.Context, key string, n int) error {
+	allow := func(ctx context.Context, key string) (*Result, error) {
+		return lb.AllowN(ctx, key, n)
+	}
+
+	sub := lb.client.Subscribe(ctx, wakeChannel(key))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return waitLoop(ctx, key, allow)
+	}
+	wake := sub.Channel()
+
+	var timer *time.Timer
 	for {
-		res, err := lb.Allow(ctx, key)
-		if err != nil {
+		res, err := allow(ctx, key)
+		if failedClosed(res, err) {
 			return err
 		}
-
 		if res.Allowed {
 			return nil
 		}
 
+		if timer == nil {
+			timer = time.NewTimer(res.WaitTime)
+			defer timer.Stop()
+		} else {
+			timer.Reset(res.WaitTime)
+		}
 		select {
-		case <-time.After(res.WaitTime):
-			continue
+		case <-timer.C:
+		case <-wake:
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
+// Release hands n unused permits back to the bucket for key and wakes waiters.
+// It is meant for permits reserved with Reserve or ReserveN that were not used.
+func (lb *LeakyBucketRedis) Release(ctx context.Context, key string, n int) error {
+	return lb.release(ctx, key, lb.rate, n)
+}
+
+// release hands n permits back to the bucket for key at the given rate
+func (lb *LeakyBucketRedis) release(ctx context.Context, key string, rate float64, n int) error {
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+
+	// Moves the TAT back by the released permits, never before now.
+	// ARGV[1]: rate, ARGV[2]: burst, ARGV[3]: now, ARGV[4]: cost, ARGV[5]: wake channel
+	script := `
+		local key = KEYS[1]
+		local rate = tonumber(ARGV[1])
+		local burst = tonumber(ARGV[2])
+		local now = tonumber(ARGV[3])
+		local cost = tonumber(ARGV[4])
+
+		local emission_interval = 1.0 / rate
+		local burst_offset = emission_interval * burst
+
+		local tat = tonumber(redis.call('GET', key))
+		if tat and tat > now then
+			local new_tat = math.max(now, tat - emission_interval * cost)
+			redis.call('SET', key, string.format('%.6f', new_tat), 'EX', math.ceil(math.max(new_tat - now, burst_offset) + emission_interval))
+		end
+
+		redis.call('PUBLISH', ARGV[5], '1')
+		return 1
+	`
+
+	_, err := redisCall(ctx, lb, func(ctx context.Context) (interface{}, error) {
+		return lb.client.Eval(ctx, script, []string{key}, rate, lb.burst, nowFloat, n, wakeChannel(key)).Result()
+	})
+	return err
+}
+
+// Reset empties the bucket for key so that the full burst is available
+// again, and wakes the waiters of every instance. Local leases of key are
+// dropped; those of other instances run out on their own.
+func (lb *LeakyBucketRedis) Reset(ctx context.Context, key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	if lb.denials != nil {
+		lb.denials.forget(key)
+	}
+	lb.dropLeases(key)
+
+	_, err := redisCall(ctx, lb, func(ctx context.Context) ([]redis.Cmder, error) {
+		return lb.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.Publish(ctx, wakeChannel(key), "1")
+			return nil
+		})
+	})
+	return err
+}
+
+// wakeChannel returns the pub/sub channel announcing returned capacity for key
+func wakeChannel(key string) string {
+	return key + ":wake"
+}
+
+// maxWaitFor returns how long a reservation made under ctx may wait
+func maxWaitFor(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return time.Duration(math.MaxInt64)
+}
+
+// waitLoop calls allow until it permits the request or ctx is done
+func waitLoop(ctx context.Context, key string, allow func(context.Context, string) (*Result, error)) error {
+	for {
+		res, err := allow(ctx, key)
+		if failedClosed(res, err) {
+			return err
+		}
+
+		if res.Allowed {
+			return nil
+		}
+
+		if !sleepContext(ctx, res.WaitTime) {
+			return ctx.Err()
+		}
+	}
+}
+
 // WaitTimeout blocks until a request for the given key is permitted,
 // the context is cancelled, or the specified timeout duration elapses.
 // It returns an error if the context is cancelled or the timeout is reached.
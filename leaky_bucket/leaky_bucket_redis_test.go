@@ -2,9 +2,14 @@ package leaky_bucket_redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,7 +18,7 @@ import (
 )
 
 // Helper function to create an in-memory test Redis client
-func createTestClient(t *testing.T) redis.UniversalClient {
+func createTestClient(t testing.TB) redis.UniversalClient {
 	s := miniredis.RunT(t)
 	
 	client := redis.NewClient(&redis.Options{
@@ -226,13 +231,124 @@ func TestLeakyBucketRedis_ContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	// Should handle cancelled context (Redis call might fail, we fail open)
+	// The Redis call fails; the error is reported along with the fail open decision
 	res, err := lb.Allow(ctx, key)
+	if !errors.Is(err, ErrBackendUnavailable) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected ErrBackendUnavailable caused by the cancellation, got %v", err)
+	}
+	if res == nil || !res.Allowed {
+		t.Error("Expected to fail open on cancelled context if it caused an error")
+	}
+}
+
+func TestLeakyBucketRedis_InvalidRate(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		lb := New(client, rate)
+		if _, err := lb.Allow(ctx, "invalid_rate"); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("Expected ErrInvalidRate for rate %v, got %v", rate, err)
+		}
+		if err := lb.Wait(ctx, "invalid_rate"); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("Expected ErrInvalidRate from Wait for rate %v, got %v", rate, err)
+		}
+		if err := lb.Pause(ctx, "invalid_rate", time.Second); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("Expected ErrInvalidRate from Pause for rate %v, got %v", rate, err)
+		}
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	res, err := parseResult([]interface{}{int64(1), "0.5", "3"}, 10)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	if !res.Allowed {
-		t.Error("Expected to fail open on cancelled context if it caused an error")
+	if !res.Allowed || res.WaitTime != 500*time.Millisecond || res.Remaining != 3 {
+		t.Errorf("Expected allowed with 500ms wait and 3 remaining, got %+v", res)
+	}
+
+	bad := [][]interface{}{
+		nil,
+		{int64(1), "0"},
+		{"1", "0", "0"},
+		{int64(1), int64(0), "0"},
+		{int64(1), "soon", "0"},
+		{int64(1), "0", "many"},
+		{int64(1), "nan", "0"},
+	}
+	for _, reply := range bad {
+		if _, err := parseResult(reply, 10); !errors.Is(err, ErrScriptReply) {
+			t.Errorf("Expected ErrScriptReply for %v, got %v", reply, err)
+		}
+	}
+}
+
+func TestGCRA_RoundingSlack(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	// The stored TAT is 0.5µs ahead of now, within the slack for rounding
+	client.Set(ctx, "slack", "100.0000005", 0)
+
+	reply, err := gcraCall.Run(ctx, client, []string{"slack"}, 10.0, 1, 100.0, 0.0, 0, 1).Slice()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res, err := parseResult(reply, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !res.Allowed || res.WaitTime != 0 {
+		t.Errorf("Expected an allowed request without wait, got %+v", res)
+	}
+}
+
+// wrappedLimiter decorates a Limiter without implementing its optional interfaces
+type wrappedLimiter struct {
+	Limiter
+}
+
+func (w wrappedLimiter) Unwrap() Limiter {
+	return w.Limiter
+}
+
+func TestAs(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 10.0)
+	wrapped := wrappedLimiter{wrappedLimiter{lb}}
+
+	if _, ok := Limiter(wrapped).(Pauser); ok {
+		t.Fatal("Expected the wrapper to hide Pauser")
+	}
+	p, ok := As[Pauser](wrapped)
+	if !ok || p != Pauser(lb) {
+		t.Errorf("Expected As to find the limiter behind the wrappers, got %v", p)
+	}
+	if _, ok := As[Pauser](wrappedLimiter{}); ok {
+		t.Error("Expected As to report false when no limiter implements the interface")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := map[error]string{
+		fmt.Errorf("%w: %w", ErrBackendUnavailable, context.DeadlineExceeded): "backend_unavailable",
+		ErrScriptReply:       "script_reply",
+		ErrInvalidKey:        "invalid_key",
+		ErrInvalidRate:       "config",
+		ErrCostExceedsBurst:  "config",
+		ErrQueueFull:         "queue_full",
+		context.Canceled:     "cancelled",
+		errors.New("custom"): "other",
+	}
+	for err, want := range cases {
+		if got := ErrorClass(err); got != want {
+			t.Errorf("Expected class %s for %v, got %s", want, err, got)
+		}
 	}
 }
 
@@ -304,3 +420,284 @@ func TestNewLeakyBucket(t *testing.T) {
 		t.Errorf("Wait returned too early for test_global_key")
 	}
 }
+
+func TestLeakyBucketRedis_Reserve(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	key := "test_bucket_reserve"
+	lb := New(client, 10.0) // 100ms interval
+	ctx := context.Background()
+
+	res, err := lb.Reserve(ctx, key, 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !res.Allowed || res.WaitTime != 0 {
+		t.Errorf("Expected first reservation to be immediate, got %+v", res)
+	}
+
+	// The next two fit into the 250ms window and are queued behind each other
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		res, _ = lb.Reserve(ctx, key, 250*time.Millisecond)
+		if !res.Allowed {
+			t.Fatalf("Reservation %d should be allowed", i+2)
+		}
+		if res.WaitTime < want-20*time.Millisecond || res.WaitTime > want+20*time.Millisecond {
+			t.Errorf("Reservation %d: Expected wait around %v, got %v", i+2, want, res.WaitTime)
+		}
+	}
+
+	// The fourth would wait ~300ms and is denied without consuming capacity
+	res, _ = lb.Reserve(ctx, key, 250*time.Millisecond)
+	if res.Allowed {
+		t.Error("Expected reservation beyond maxWait to be denied")
+	}
+	again, _ := lb.Reserve(ctx, key, 250*time.Millisecond)
+	if again.WaitTime > res.WaitTime+20*time.Millisecond {
+		t.Errorf("Denied reservation consumed capacity: wait grew from %v to %v", res.WaitTime, again.WaitTime)
+	}
+}
+
+func TestLeakyBucketRedis_Pause(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	key := "test_bucket_pause"
+	lb := New(client, 100.0, WithBurst(5))
+	ctx := context.Background()
+
+	if err := lb.Pause(ctx, key, 500*time.Millisecond); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+
+	res, _ := lb.Allow(ctx, key)
+	if res.Allowed {
+		t.Fatal("Expected paused bucket to deny requests despite free burst")
+	}
+	if res.WaitTime < 450*time.Millisecond || res.WaitTime > 500*time.Millisecond {
+		t.Errorf("Expected wait around 500ms, got %v", res.WaitTime)
+	}
+
+	// A shorter pause does not cut the longer one short
+	lb.Pause(ctx, key, 10*time.Millisecond)
+	if res, _ := lb.Allow(ctx, key); res.Allowed {
+		t.Error("Expected shorter pause to keep the existing one")
+	}
+}
+
+func TestLeakyBucketRedis_WaitFIFO(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 20.0)
+	ctx := context.Background()
+	key := "test_wait_fifo"
+	lb.Allow(ctx, key)
+
+	// Waiters arriving in order are served in order, 50ms apart
+	done := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			if err := lb.Wait(ctx, key); err == nil {
+				done <- i
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for want := 0; want < 3; want++ {
+		select {
+		case got := <-done:
+			if got != want {
+				t.Errorf("Expected waiter %d to be served next, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Waiter %d was never served", want)
+		}
+	}
+}
+
+func TestLeakyBucketRedis_WaitCancelReleases(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 2.0)
+	ctx := context.Background()
+	key := "test_wait_release"
+	lb.Allow(ctx, key)
+
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := lb.Wait(cctx, key); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	// The cancelled slot was returned: the next one is due after ~480ms, not ~980ms
+	res, _ := lb.Reserve(ctx, key, 2*time.Second)
+	if res.WaitTime > 500*time.Millisecond {
+		t.Errorf("Expected cancelled slot to be released, next wait is %v", res.WaitTime)
+	}
+}
+
+func TestLeakyBucketRedis_Reset(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 1.0/3600.0, WithBurst(2))
+	ctx := context.Background()
+	key := "test_reset"
+	lb.Allow(ctx, key)
+	lb.Allow(ctx, key)
+
+	if err := lb.Reset(ctx, key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res, _ := lb.Allow(ctx, key); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Expected full burst after Reset, got %+v", res)
+	}
+	if err := lb.Reset(ctx, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestLeakyBucketRedis_WaitWakeups(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 1.0/3600.0, WithWakeups())
+	ctx := context.Background()
+	key := "test_wait_wakeups"
+	lb.Allow(ctx, key)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- lb.WaitTimeout(ctx, key, time.Second)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	New(client, 1.0/3600.0).Reset(ctx, key)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Expected waiter to be granted after Reset, got %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Waiter was not woken by Reset")
+	}
+}
+
+func TestLeakyBucketRedis_Batching(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(10), WithBatching(time.Millisecond))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := lb.Allow(ctx, "test_batching")
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			if res.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 10 {
+		t.Errorf("Expected exactly the burst of 10 to be allowed, got %d", allowed.Load())
+	}
+
+	// Scripts flushed from Redis are loaded again
+	client.ScriptFlush(ctx)
+	if res, _ := lb.Allow(ctx, "test_batching_reload"); !res.Allowed || res.Remaining != 9 {
+		t.Errorf("Expected check to succeed after SCRIPT FLUSH, got %+v", res)
+	}
+}
+
+// latencyConn delays every write to simulate the network round trip to Redis
+type latencyConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c latencyConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+// benchmarkAllowParallel measures concurrent Allow calls on 64 keys over a
+// connection with a 200µs round trip, where the savings of batching show
+func benchmarkAllowParallel(b *testing.B, opts ...Option) {
+	s := miniredis.RunT(b)
+	client := redis.NewClient(&redis.Options{
+		Addr: s.Addr(),
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return latencyConn{Conn: conn, delay: 200 * time.Microsecond}, err
+		},
+	})
+	defer client.Close()
+
+	lb := New(client, 1e9, append([]Option{WithBurst(1000)}, opts...)...)
+	ctx := context.Background()
+	var n atomic.Int64
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key := fmt.Sprintf("bench_%d", n.Add(1)%64)
+		for pb.Next() {
+			lb.Allow(ctx, key)
+		}
+	})
+}
+
+func BenchmarkAllow_Parallel(b *testing.B) {
+	benchmarkAllowParallel(b)
+}
+
+func BenchmarkAllow_ParallelBatched(b *testing.B) {
+	benchmarkAllowParallel(b, WithBatching(200*time.Microsecond))
+}
+
+func TestLeakyBucketRedis_BatchingCluster(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{s.Addr()}})
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(3), WithBatching(time.Millisecond))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, _ := lb.Allow(ctx, fmt.Sprintf("test_batching_cluster_%d", i%2)); res.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 6 {
+		t.Errorf("Expected all 6 checks on two keys with a burst of 3 to be allowed, got %d", allowed.Load())
+	}
+	if res, _ := lb.Allow(ctx, "test_batching_cluster_0"); res.Allowed {
+		t.Error("Expected the 4th check on a key to be denied")
+	}
+}
@@ -0,0 +1,197 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// leaseSweepSize is the number of leases above which expired ones are dropped
+const leaseSweepSize = 1024
+
+// defaultLeaseTTL is the lease lifetime used when WithLeasing is given none
+const defaultLeaseTTL = 100 * time.Millisecond
+
+// lease holds permits claimed from the Redis bucket and served locally
+type lease struct {
+	tokens  int
+	expires time.Time
+}
+
+// bucketKey identifies local state kept for a bucket; priorities are kept
+// apart because they may use different parts of the burst
+type bucketKey struct {
+	key      string
+	priority Priority
+}
+
+// WithLeasing makes Allow claim up to batch permits at once from the Redis
+// bucket and serve them from memory until they are used up or ttl elapses.
+// Unused permits are handed back with the next claim or ReleaseLeases.
+//
+// Larger batches and longer ttls mean fewer round trips, but each instance may
+// then admit up to batch requests in a row that other instances cannot see,
+// and leased permits may be spent up to ttl after the bucket granted them.
+// Batch is at least 1, and a ttl that is not positive defaults to 100ms.
+func WithLeasing(batch int, ttl time.Duration) Option {
+	return func(lb *LeakyBucketRedis) {
+		if ttl <= 0 {
+			ttl = defaultLeaseTTL
+		}
+		lb.leaseBatch = max(batch, 1)
+		lb.leaseTTL = ttl
+		lb.leases = make(map[bucketKey]*lease)
+	}
+}
+
+// allowLeased serves Allow from the local lease for key, claiming a new batch when it is exhausted
+func (lb *LeakyBucketRedis) allowLeased(ctx context.Context, key string) (*Result, error) {
+	if lb.err != nil {
+		return nil, lb.err
+	}
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+
+	lk := bucketKey{key: key, priority: PriorityFromContext(ctx)}
+	now := time.Now()
+
+	lb.leaseMu.Lock()
+	l := lb.leases[lk]
+	if l != nil && l.tokens > 0 && now.Before(l.expires) {
+		l.tokens--
+		res := &Result{Allowed: true, Remaining: l.tokens, Limit: lb.rate, Source: SourceLease}
+		lb.leaseMu.Unlock()
+		return res, nil
+	}
+	var unused int
+	if l != nil {
+		unused = l.tokens
+		delete(lb.leases, lk)
+	}
+	lb.leaseMu.Unlock()
+
+	if lb.denials != nil && unused == 0 {
+		if res, ok := lb.denials.check(lk, 0, lb.rate); ok {
+			return res, nil
+		}
+	}
+
+	res, n, err := lb.claim(ctx, key, unused)
+	if err != nil {
+		return lb.fallback(ctx, key, call{rate: lb.rate, cost: 1}, lb.reserved(lk.priority), err)
+	}
+	if n == 0 {
+		if lb.denials != nil {
+			lb.denials.remember(lk, res)
+		}
+		return res, nil
+	}
+
+	lb.leaseMu.Lock()
+	defer lb.leaseMu.Unlock()
+	if len(lb.leases) >= leaseSweepSize {
+		for k, l := range lb.leases {
+			if !now.Before(l.expires) {
+				delete(lb.leases, k)
+			}
+		}
+	}
+	if l := lb.leases[lk]; l != nil && now.Before(l.expires) {
+		// A concurrent claim won the race; pool the permits
+		l.tokens += n - 1
+	} else {
+		lb.leases[lk] = &lease{tokens: n - 1, expires: now.Add(lb.leaseTTL)}
+	}
+	return res, nil
+}
+
+// claim takes up to leaseBatch permits from the bucket for key in one round
+// trip, after handing back returned unused permits. It returns the number of
+// permits claimed, or 0 with the denied Result.
+func (lb *LeakyBucketRedis) claim(ctx context.Context, key string, returned int) (*Result, int, error) {
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+
+	// ARGV[1]: rate, ARGV[2]: burst, ARGV[3]: now, ARGV[4]: reserved,
+	// ARGV[5]: batch (most permits to claim), ARGV[6]: returned (unused permits handed back)
+	script := `
+		local key = KEYS[1]
+		local rate = tonumber(ARGV[1])
+		local burst = tonumber(ARGV[2])
+		local now = tonumber(ARGV[3])
+		local reserved = tonumber(ARGV[4])
+		local batch = tonumber(ARGV[5])
+		local returned = tonumber(ARGV[6])
+
+		local emission_interval = 1.0 / rate
+		local burst_offset = emission_interval * burst
+		local usable_offset = burst_offset - emission_interval * reserved
+
+		local tat = tonumber(redis.call('GET', key) or now)
+		if returned > 0 and tat > now then
+			tat = math.max(now, tat - emission_interval * returned)
+		end
+		local base = math.max(tat, now)
+
+		local available = math.floor((now - base + usable_offset + 1e-6) / emission_interval)
+		local n = math.min(batch, available)
+		local new_tat = base + emission_interval * math.max(n, 0)
+
+		redis.call('SET', key, string.format('%.6f', new_tat), 'EX', math.ceil(math.max(new_tat - now, burst_offset) + emission_interval))
+
+		if n < 1 then
+			return {0, tostring(base + emission_interval - usable_offset - now), "0"}
+		end
+		return {n, "0", tostring(available - n)}
+	`
+
+	reply, err := redisCall(ctx, lb, func(ctx context.Context) ([]interface{}, error) {
+		return lb.client.Eval(ctx, script, []string{key}, lb.rate, lb.burst, nowFloat, lb.reserved(PriorityFromContext(ctx)), lb.leaseBatch, returned).Slice()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	claimed, waitSecs, remaining, err := parseReply(reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := int(claimed)
+	return &Result{
+		Allowed:   n > 0,
+		WaitTime:  time.Duration(waitSecs * float64(time.Second)),
+		Remaining: remaining,
+		Limit:     lb.rate,
+	}, n, nil
+}
+
+// dropLeases forgets the local leases of key without handing them back
+func (lb *LeakyBucketRedis) dropLeases(key string) {
+	lb.leaseMu.Lock()
+	defer lb.leaseMu.Unlock()
+
+	for lk := range lb.leases {
+		if lk.key == key {
+			delete(lb.leases, lk)
+		}
+	}
+}
+
+// ReleaseLeases hands the unused permits of all leases back to the Redis
+// bucket, e.g. on shutdown
+func (lb *LeakyBucketRedis) ReleaseLeases(ctx context.Context) error {
+	lb.leaseMu.Lock()
+	leases := lb.leases
+	if leases != nil {
+		lb.leases = make(map[bucketKey]*lease)
+	}
+	lb.leaseMu.Unlock()
+
+	var errs []error
+	for lk, l := range leases {
+		if l.tokens > 0 {
+			errs = append(errs, lb.Release(ctx, lk.key, l.tokens))
+		}
+	}
+	return errors.Join(errs...)
+}
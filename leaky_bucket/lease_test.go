@@ -0,0 +1,123 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// allowedCount calls Allow n times and counts the allowed requests
+func allowedCount(t *testing.T, l Limiter, key string, n int) int {
+	t.Helper()
+
+	allowed := 0
+	for i := 0; i < n; i++ {
+		res, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if res.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestLeasing_ServesLocally(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(10), WithLeasing(5, time.Minute))
+	ctx := context.Background()
+	key := "lease_local"
+
+	lb.Allow(ctx, key)
+	tat := client.Get(ctx, key).Val()
+
+	if n := allowedCount(t, lb, key, 4); n != 4 {
+		t.Errorf("Expected the rest of the batch to be allowed, got %d", n)
+	}
+	if got := client.Get(ctx, key).Val(); got != tat {
+		t.Errorf("Expected leased permits to be served without Redis, TAT moved from %s to %s", tat, got)
+	}
+}
+
+func TestLeasing_SharedAcrossInstances(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	a := New(client, 0.01, WithBurst(10), WithLeasing(4, time.Minute))
+	b := New(client, 0.01, WithBurst(10), WithLeasing(4, time.Minute))
+	key := "lease_shared"
+
+	// a uses its whole lease; b claims the remaining 6 in two batches
+	total := allowedCount(t, a, key, 4) + allowedCount(t, b, key, 8)
+	if total != 10 {
+		t.Errorf("Expected the instances to share 10 permits, got %d", total)
+	}
+
+	res, _ := b.Allow(context.Background(), key)
+	if res.Allowed || res.WaitTime <= 0 {
+		t.Errorf("Expected a denial with a wait time once the bucket is empty, got %+v", res)
+	}
+}
+
+func TestLeasing_ReturnsUnused(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	leased := New(client, 0.01, WithBurst(10), WithLeasing(5, 20*time.Millisecond))
+	direct := New(client, 0.01, WithBurst(10))
+	ctx := context.Background()
+
+	// Expired leases hand back their unused permits with the next claim
+	leased.Allow(ctx, "lease_expired")
+	time.Sleep(30 * time.Millisecond)
+	leased.Allow(ctx, "lease_expired")
+	if n := allowedCount(t, direct, "lease_expired", 10); n != 4 {
+		t.Errorf("Expected 4 permits left after 2 used and 4 leased, got %d", n)
+	}
+
+	// ReleaseLeases hands back everything still leased
+	leased.Allow(ctx, "lease_released")
+	if err := leased.ReleaseLeases(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := allowedCount(t, direct, "lease_released", 10); n != 9 {
+		t.Errorf("Expected 9 permits left after releasing the lease, got %d", n)
+	}
+}
+
+func TestLeasing_Reset(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(10), WithLeasing(5, time.Minute))
+	ctx := context.Background()
+	key := "lease_reset"
+
+	lb.Allow(ctx, key)
+	if err := lb.Reset(ctx, key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The lease claimed before the reset is gone, so Allow claims from Redis again
+	lb.Allow(ctx, key)
+	if n := client.Exists(ctx, key).Val(); n != 1 {
+		t.Error("Expected Allow to claim a new lease from Redis after Reset")
+	}
+}
+
+func TestLeasing_InvalidArguments(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 10.0, WithBurst(5), WithLeasing(0, 0))
+	res, err := lb.Allow(context.Background(), "lease_invalid")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !res.Allowed || res.WaitTime != 0 {
+		t.Errorf("Expected a batch of at least 1 to be allowed, got %+v", res)
+	}
+}
@@ -0,0 +1,218 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ListenerPolicy decides what a Listener does with connections over the limit
+type ListenerPolicy int
+
+const (
+	// ListenerReject closes connections over the limit immediately.
+	ListenerReject ListenerPolicy = iota
+	// ListenerDelay holds connections until the rate allows them, up to the
+	// maximum delay, and closes those that would have to wait longer.
+	// Limiters that do not implement Reserver reject instead of delaying.
+	ListenerDelay
+)
+
+// ListenerStats holds the connection counters of a Listener
+type ListenerStats struct {
+	Accepted uint64 // Accepted is the number of connections handed out by Accept.
+	Rejected uint64 // Rejected is the number of connections closed because of the limit.
+	Delayed  uint64 // Delayed is the number of accepted connections that were held back first.
+}
+
+// Listener is a net.Listener that limits how fast each source can open
+// connections. Connections are keyed by the prefix of their remote address,
+// so that a single IPv6 client cannot evade the limit by rotating addresses.
+type Listener struct {
+	net.Listener
+	limiter  Limiter
+	policy   ListenerPolicy
+	maxDelay time.Duration
+	v4Bits   int
+	v6Bits   int
+
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+	delayed  atomic.Uint64
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	ready     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+}
+
+// ListenerOption configures a Listener
+type ListenerOption func(*Listener)
+
+// WithListenerPolicy sets the policy for connections over the limit (default is ListenerReject).
+// maxDelay is the longest a connection is held with ListenerDelay.
+func WithListenerPolicy(policy ListenerPolicy, maxDelay time.Duration) ListenerOption {
+	return func(l *Listener) {
+		l.policy = policy
+		l.maxDelay = maxDelay
+	}
+}
+
+// WithPrefixBits sets the IPv4 and IPv6 prefix lengths connections are keyed by (default is 32 and 64)
+func WithPrefixBits(v4Bits, v6Bits int) ListenerOption {
+	return func(l *Listener) {
+		l.v4Bits = v4Bits
+		l.v6Bits = v6Bits
+	}
+}
+
+// NewListener wraps inner so that every accepted connection is checked against limiter
+func NewListener(inner net.Listener, limiter Limiter, opts ...ListenerOption) *Listener {
+	l := &Listener{
+		Listener: inner,
+		limiter:  limiter,
+		v4Bits:   32,
+		v6Bits:   64,
+		ready:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Stats returns the connection counters
+func (l *Listener) Stats() ListenerStats {
+	return ListenerStats{
+		Accepted: l.accepted.Load(),
+		Rejected: l.rejected.Load(),
+		Delayed:  l.delayed.Load(),
+	}
+}
+
+// connKey returns the rate limiting key for a remote address
+func (l *Listener) connKey(addr net.Addr) string {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return "conn:" + addr.String()
+	}
+	ip := ap.Addr().Unmap()
+	bits := l.v6Bits
+	if ip.Is4() {
+		bits = l.v4Bits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return "conn:" + ip.String()
+	}
+	return "conn:" + prefix.String()
+}
+
+// Accept waits for and returns the next connection that is within the limit
+func (l *Listener) Accept() (net.Conn, error) {
+	if l.policy == ListenerDelay {
+		l.startOnce.Do(func() { go l.acceptLoop() })
+		select {
+		case conn := <-l.ready:
+			l.accepted.Add(1)
+			return conn, nil
+		case err := <-l.errs:
+			return nil, err
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		res, err := l.limiter.Allow(context.Background(), l.connKey(conn.RemoteAddr()))
+		if res == nil || res.Allowed {
+			l.accepted.Add(1)
+			return conn, nil
+		}
+		l.rejected.Add(1)
+		conn.Close()
+	}
+}
+
+// acceptLoop accepts connections in the background for ListenerDelay, so that
+// a held connection does not block connections from other sources
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		// Limiters that cannot reserve are checked with Allow instead, which never delays
+		var res *Result
+		key := l.connKey(conn.RemoteAddr())
+		if rsv, ok := As[Reserver](l.limiter); ok {
+			res, _ = rsv.Reserve(context.Background(), key, l.maxDelay)
+		} else {
+			res, _ = l.limiter.Allow(context.Background(), key)
+		}
+		if res != nil && !res.Allowed {
+			l.rejected.Add(1)
+			conn.Close()
+			continue
+		}
+
+		var wait time.Duration
+		if res != nil {
+			wait = res.WaitTime
+		}
+
+		if wait == 0 {
+			l.deliver(conn)
+			continue
+		}
+
+		l.delayed.Add(1)
+		go func() {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				l.deliver(conn)
+			case <-l.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// deliver hands conn to Accept, or closes it if the listener is closed first
+func (l *Listener) deliver(conn net.Conn) {
+	select {
+	case l.ready <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Close closes the listener and any connections still held back
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
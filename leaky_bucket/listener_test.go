@@ -0,0 +1,204 @@
+package leaky_bucket_redis
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialAndProbe opens n connections to addr and reports for each whether the server kept it open
+func dialAndProbe(t *testing.T, addr string, n int) []bool {
+	t.Helper()
+
+	conns := make([]net.Conn, n)
+	for i := range conns {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+
+	open := make([]bool, n)
+	for i, c := range conns {
+		c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, err := c.Read(make([]byte, 1))
+		var netErr net.Error
+		open[i] = errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return open
+}
+
+// serve accepts connections on l and keeps them open until the test ends
+func serve(t *testing.T, l net.Listener) {
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+}
+
+func TestListener_Reject(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	l := NewListener(inner, New(client, 0.01, WithBurst(2)))
+	defer l.Close()
+	serve(t, l)
+
+	open := dialAndProbe(t, l.Addr().String(), 4)
+
+	kept := 0
+	for _, o := range open {
+		if o {
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Errorf("Expected 2 connections to be kept, got %d (%v)", kept, open)
+	}
+
+	stats := l.Stats()
+	if stats.Accepted != 2 || stats.Rejected != 2 {
+		t.Errorf("Expected 2 accepted and 2 rejected, got %+v", stats)
+	}
+}
+
+func TestListener_Delay(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	// 100ms between connections, held for at most 250ms: the 4th is closed
+	l := NewListener(inner, New(client, 10.0), WithListenerPolicy(ListenerDelay, 250*time.Millisecond))
+	defer l.Close()
+
+	accepted := make(chan time.Time, 4)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- time.Now()
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+
+	start := time.Now()
+	open := dialAndProbe(t, l.Addr().String(), 4)
+
+	if open[3] {
+		t.Error("Expected connection beyond the max delay to be closed")
+	}
+	if len(accepted) != 3 {
+		t.Fatalf("Expected 3 accepted connections, got %d", len(accepted))
+	}
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		last = <-accepted
+	}
+	if last.Sub(start) < 150*time.Millisecond {
+		t.Errorf("Expected the third connection to be held back, accepted after %v", last.Sub(start))
+	}
+
+	stats := l.Stats()
+	if stats.Accepted != 3 || stats.Delayed != 2 || stats.Rejected != 1 {
+		t.Errorf("Expected 3 accepted, 2 delayed and 1 rejected, got %+v", stats)
+	}
+}
+
+// plainLimiter hides the optional interfaces of a Limiter, including from As
+type plainLimiter struct {
+	Limiter
+}
+
+func TestListener_DelayWithoutReserver(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	limiter := plainLimiter{New(client, 0.01, WithBurst(2))}
+	l := NewListener(inner, limiter, WithListenerPolicy(ListenerDelay, time.Second))
+	defer l.Close()
+	serve(t, l)
+
+	open := dialAndProbe(t, l.Addr().String(), 4)
+
+	if open[2] || open[3] {
+		t.Errorf("Expected connections over the limit to be closed, got %v", open)
+	}
+	stats := l.Stats()
+	if stats.Accepted != 2 || stats.Rejected != 2 || stats.Delayed != 0 {
+		t.Errorf("Expected 2 accepted and 2 rejected, got %+v", stats)
+	}
+}
+
+func TestListener_Close(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	l := NewListener(inner, New(client, 10.0), WithListenerPolicy(ListenerDelay, time.Second))
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		errc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	l.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestListener_ConnKey(t *testing.T) {
+	l := NewListener(nil, nil, WithPrefixBits(24, 48))
+
+	tests := map[string]string{
+		"192.0.2.55:1234":            "conn:192.0.2.0/24",
+		"[2001:db8:1:2::7]:443":      "conn:2001:db8:1::/48",
+		"[::ffff:198.51.100.9]:8080": "conn:198.51.100.0/24",
+	}
+	for addr, want := range tests {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr(%s): %v", addr, err)
+		}
+		if got := l.connKey(tcp); got != want {
+			t.Errorf("connKey(%s) = %s, want %s", addr, got, want)
+		}
+	}
+
+	unix := &net.UnixAddr{Name: "/tmp/app.sock", Net: "unix"}
+	if got := l.connKey(unix); got != "conn:/tmp/app.sock" {
+		t.Errorf("Expected non-IP addresses to be used verbatim, got %s", got)
+	}
+}
@@ -0,0 +1,139 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ClosePolicyViolation is the WebSocket close code (RFC 6455) for connections closed over the message limit
+const ClosePolicyViolation = 1008
+
+// ErrMessageDropped is returned by MessageLimiter.Check when a message over the limit should be discarded
+var ErrMessageDropped = errors.New("message dropped: rate limit exceeded")
+
+// CloseError is returned by MessageLimiter.Check when the connection should be closed.
+// Code and Reason are meant for the close frame of the protocol in use.
+type CloseError struct {
+	Code   int     // Code is the close code, ClosePolicyViolation.
+	Reason string  // Reason is a short human-readable close reason.
+	Result *Result // Result is the limiter decision that triggered the close.
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("close connection (%d): %s", e.Code, e.Reason)
+}
+
+// MessagePolicy decides what MessageLimiter.Check does with messages over the limit
+type MessagePolicy int
+
+const (
+	// MessageDrop makes Check return ErrMessageDropped; the connection stays open.
+	MessageDrop MessagePolicy = iota
+	// MessageDelay makes Check hold the message until the rate allows it, up
+	// to the maximum delay. Messages that would wait longer are dropped.
+	// It requires a limiter implementing Reserver.
+	MessageDelay
+	// MessageClose makes Check return a *CloseError with ClosePolicyViolation.
+	MessageClose
+)
+
+// MessageStats holds the counters of a MessageLimiter
+type MessageStats struct {
+	Allowed uint64 // Allowed is the number of messages let through, including delayed ones.
+	Delayed uint64 // Delayed is the number of messages that were held back first.
+	Dropped uint64 // Dropped is the number of messages rejected by MessageDrop or MessageDelay.
+	Closed  uint64 // Closed is the number of checks that asked for the connection to be closed.
+}
+
+// MessageLimiter limits the messages received on a long-lived connection
+// such as a WebSocket or a server-sent stream. Create one per connection; all
+// connections using the same key, on any instance, share one Redis bucket.
+//
+// It is framework-agnostic: call Check for every incoming message and act
+// on the returned error.
+type MessageLimiter struct {
+	limiter  Limiter
+	key      string
+	policy   MessagePolicy
+	maxDelay time.Duration
+
+	allowed atomic.Uint64
+	delayed atomic.Uint64
+	dropped atomic.Uint64
+	closed  atomic.Uint64
+}
+
+// MessageOption configures a MessageLimiter
+type MessageOption func(*MessageLimiter)
+
+// WithMessagePolicy sets the policy for messages over the limit (default is MessageDrop).
+// maxDelay is the longest a message is held with MessageDelay.
+func WithMessagePolicy(policy MessagePolicy, maxDelay time.Duration) MessageOption {
+	return func(m *MessageLimiter) {
+		m.policy = policy
+		m.maxDelay = maxDelay
+	}
+}
+
+// NewMessageLimiter creates a MessageLimiter for one connection of key, typically a user ID
+func NewMessageLimiter(limiter Limiter, key string, opts ...MessageOption) *MessageLimiter {
+	m := &MessageLimiter{
+		limiter: limiter,
+		key:     key,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Check is called for every incoming message. It returns nil when the
+// message may be processed, ErrMessageDropped when it should be discarded,
+// a *CloseError when the connection should be closed, or the context error
+// if ctx ends while a message is delayed. Limiter errors fail open.
+func (m *MessageLimiter) Check(ctx context.Context) error {
+	var res *Result
+	if rsv, ok := As[Reserver](m.limiter); ok && m.policy == MessageDelay {
+		res, _ = rsv.Reserve(ctx, m.key, m.maxDelay)
+	} else {
+		res, _ = m.limiter.Allow(ctx, m.key)
+	}
+	if res == nil {
+		m.allowed.Add(1)
+		return nil
+	}
+
+	if res.Allowed {
+		if res.WaitTime > 0 {
+			m.delayed.Add(1)
+			if !sleepContext(ctx, res.WaitTime) {
+				releaseSlot(ctx, m.limiter, m.key, res)
+				return ctx.Err()
+			}
+		}
+		m.allowed.Add(1)
+		return nil
+	}
+
+	if m.policy == MessageClose {
+		m.closed.Add(1)
+		return &CloseError{Code: ClosePolicyViolation, Reason: "message rate limit exceeded", Result: res}
+	}
+	m.dropped.Add(1)
+	return ErrMessageDropped
+}
+
+// Stats returns the message counters of this connection
+func (m *MessageLimiter) Stats() MessageStats {
+	return MessageStats{
+		Allowed: m.allowed.Load(),
+		Delayed: m.delayed.Load(),
+		Dropped: m.dropped.Load(),
+		Closed:  m.closed.Load(),
+	}
+}
@@ -0,0 +1,92 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageLimiter_Drop(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(3))
+	ctx := context.Background()
+
+	// Two connections of the same user share the bucket
+	connA := NewMessageLimiter(lb, "ws:user1")
+	connB := NewMessageLimiter(lb, "ws:user1")
+
+	for i := 0; i < 2; i++ {
+		if err := connA.Check(ctx); err != nil {
+			t.Fatalf("Message %d: Unexpected error: %v", i+1, err)
+		}
+	}
+	if err := connB.Check(ctx); err != nil {
+		t.Fatalf("Expected third message to pass, got %v", err)
+	}
+	if err := connB.Check(ctx); !errors.Is(err, ErrMessageDropped) {
+		t.Errorf("Expected ErrMessageDropped, got %v", err)
+	}
+
+	if stats := connB.Stats(); stats.Allowed != 1 || stats.Dropped != 1 {
+		t.Errorf("Expected 1 allowed and 1 dropped on connection B, got %+v", stats)
+	}
+
+	// Another user is unaffected
+	if err := NewMessageLimiter(lb, "ws:user2").Check(ctx); err != nil {
+		t.Errorf("Expected another user's message to pass, got %v", err)
+	}
+}
+
+func TestMessageLimiter_Close(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m := NewMessageLimiter(New(client, 0.01), "ws:closer", WithMessagePolicy(MessageClose, 0))
+	ctx := context.Background()
+
+	m.Check(ctx)
+	err := m.Check(ctx)
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected *CloseError, got %v", err)
+	}
+	if closeErr.Code != ClosePolicyViolation {
+		t.Errorf("Expected close code 1008, got %d", closeErr.Code)
+	}
+	if closeErr.Result == nil || closeErr.Result.Allowed {
+		t.Errorf("Expected the denied result to be attached, got %+v", closeErr.Result)
+	}
+}
+
+func TestMessageLimiter_Delay(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m := NewMessageLimiter(New(client, 10.0), "ws:delay", WithMessagePolicy(MessageDelay, 150*time.Millisecond))
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := m.Check(ctx); err != nil {
+			t.Fatalf("Message %d: Expected to be delayed and pass, got %v", i+1, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected second message to be held back, took %v", elapsed)
+	}
+
+	if stats := m.Stats(); stats.Allowed != 2 || stats.Delayed != 1 {
+		t.Errorf("Expected 2 allowed of which 1 delayed, got %+v", stats)
+	}
+
+	// A cancelled context ends the delay
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := m.Check(cctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error while delayed, got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+// Package metrics exports Prometheus metrics for rate limiters.
+//
+// Metrics wraps any leaky_bucket.Limiter in a decorator that counts its
+// decisions and measures its latency. Labels are limited to values with a
+// bounded number of variants: the limiter name given to Wrap, the route
+// pattern attached with leaky_bucket.WithRouteLabel, the decision, the source
+// of the decision and the class of error. Keys never become labels.
+// Decisions of shadow checks, see leaky_bucket.ContextWithShadow, are counted
+// apart so that they never mix with enforced ones.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	leaky_bucket "github.com/alibazlamit/leaky_bucket_redis/v2/leaky_bucket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option configures the Metrics
+type Option func(*config)
+
+type config struct {
+	namespace    string
+	checkBuckets []float64
+	waitBuckets  []float64
+	constLabels  prometheus.Labels
+}
+
+// WithNamespace sets the prefix of the metric names (default is "leaky_bucket")
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithCheckBuckets sets the buckets in seconds of the check latency histogram
+func WithCheckBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.checkBuckets = buckets
+	}
+}
+
+// WithWaitBuckets sets the buckets in seconds of the wait duration histogram
+func WithWaitBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.waitBuckets = buckets
+	}
+}
+
+// WithConstLabels adds labels with fixed values to every metric, e.g. the service name
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(c *config) {
+		c.constLabels = labels
+	}
+}
+
+// Metrics holds the Prometheus collectors shared by all the limiters it wraps
+type Metrics struct {
+	decisions *prometheus.CounterVec   // limiter, route, decision, source
+	shadow    *prometheus.CounterVec   // limiter, route, decision, source
+	checks    *prometheus.HistogramVec // limiter, source
+	errors    *prometheus.CounterVec   // limiter, error
+	failOpen  *prometheus.CounterVec   // limiter, route
+	waits     *prometheus.HistogramVec // limiter, route, outcome
+	breaker   *prometheus.Desc         // limiter
+
+	mu       sync.Mutex
+	breakers map[string]breakerStater // Wrapped limiters that report a circuit breaker state, by name
+}
+
+// errNotWeighted is returned by AllowN and WaitN for several permits when the
+// wrapped limiter is not a leaky_bucket.WeightedLimiter
+var errNotWeighted = errors.New("metrics: wrapped limiter does not implement leaky_bucket.WeightedLimiter")
+
+// breakerStater is implemented by limiters with a circuit breaker, such as leaky_bucket.LeakyBucketRedis
+type breakerStater interface {
+	BreakerState() leaky_bucket.BreakerState
+}
+
+// New creates the collectors and registers them with reg, which is
+// prometheus.DefaultRegisterer if nil
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	c := &config{
+		namespace:    "leaky_bucket",
+		checkBuckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		waitBuckets:  prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	m := &Metrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   c.namespace,
+			Name:        "decisions_total",
+			Help:        "Rate limit decisions by limiter, route, decision (allowed or denied) and source.",
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "route", "decision", "source"}),
+		shadow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   c.namespace,
+			Name:        "shadow_decisions_total",
+			Help:        "Decisions of shadow checks, which are not enforced, by limiter, route, decision and source.",
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "route", "decision", "source"}),
+		checks: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   c.namespace,
+			Name:        "check_duration_seconds",
+			Help:        "Latency of rate limit checks, including the Redis script, by limiter and source.",
+			Buckets:     c.checkBuckets,
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "source"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   c.namespace,
+			Name:        "errors_total",
+			Help:        "Errors returned by rate limit checks, by limiter and class.",
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "error"}),
+		failOpen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   c.namespace,
+			Name:        "fail_open_total",
+			Help:        "Requests let through because the limiter failed, by limiter and route.",
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "route"}),
+		waits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   c.namespace,
+			Name:        "wait_duration_seconds",
+			Help:        "Time spent waiting for a permit, by limiter, route and outcome (acquired, cancelled or error).",
+			Buckets:     c.waitBuckets,
+			ConstLabels: c.constLabels,
+		}, []string{"limiter", "route", "outcome"}),
+		breaker: prometheus.NewDesc(
+			prometheus.BuildFQName(c.namespace, "", "breaker_state"),
+			"State of the circuit breaker in front of Redis: 0 closed, 1 open, 2 half-open.",
+			[]string{"limiter"}, c.constLabels,
+		),
+		breakers: make(map[string]breakerStater),
+	}
+
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.decisions.Describe(ch)
+	m.shadow.Describe(ch)
+	m.checks.Describe(ch)
+	m.errors.Describe(ch)
+	m.failOpen.Describe(ch)
+	m.waits.Describe(ch)
+	ch <- m.breaker
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.decisions.Collect(ch)
+	m.shadow.Collect(ch)
+	m.checks.Collect(ch)
+	m.errors.Collect(ch)
+	m.failOpen.Collect(ch)
+	m.waits.Collect(ch)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, b := range m.breakers {
+		ch <- prometheus.MustNewConstMetric(m.breaker, prometheus.GaugeValue, float64(b.BreakerState()), name)
+	}
+}
+
+// Wrap returns limiter instrumented under name. Name must identify the
+// limiter among those wrapped by m, e.g. "api" or "login".
+func (m *Metrics) Wrap(limiter leaky_bucket.Limiter, name string) *Limiter {
+	if b, ok := leaky_bucket.As[breakerStater](limiter); ok {
+		m.mu.Lock()
+		m.breakers[name] = b
+		m.mu.Unlock()
+	}
+	return &Limiter{next: limiter, name: name, metrics: m}
+}
+
+// Limiter is a leaky_bucket.Limiter that records metrics for every call.
+// It also implements leaky_bucket.Reserver and leaky_bucket.WeightedLimiter
+// so that shaping and bandwidth limiting are recorded too; the adapters reach
+// other optional interfaces, such as leaky_bucket.Pauser, through Unwrap.
+type Limiter struct {
+	next    leaky_bucket.Limiter
+	name    string
+	metrics *Metrics
+}
+
+// Unwrap returns the wrapped limiter
+func (l *Limiter) Unwrap() leaky_bucket.Limiter {
+	return l.next
+}
+
+// Allow checks the wrapped limiter and records the decision
+func (l *Limiter) Allow(ctx context.Context, key string) (*leaky_bucket.Result, error) {
+	start := time.Now()
+	res, err := l.next.Allow(ctx, key)
+	l.record(ctx, res, err, time.Since(start))
+	return res, err
+}
+
+// Reserve reserves through the wrapped limiter if it is a leaky_bucket.Reserver,
+// or else checks it with Allow, and records the decision
+func (l *Limiter) Reserve(ctx context.Context, key string, maxWait time.Duration) (*leaky_bucket.Result, error) {
+	rsv, ok := leaky_bucket.As[leaky_bucket.Reserver](l.next)
+	if !ok {
+		return l.Allow(ctx, key)
+	}
+
+	start := time.Now()
+	res, err := rsv.Reserve(ctx, key, maxWait)
+	l.record(ctx, res, err, time.Since(start))
+	return res, err
+}
+
+// AllowN checks a request costing n permits through the wrapped limiter and
+// records the decision. Limiters that are not a leaky_bucket.WeightedLimiter
+// only support n == 1.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (*leaky_bucket.Result, error) {
+	wl, ok := leaky_bucket.As[leaky_bucket.WeightedLimiter](l.next)
+	if !ok {
+		if n != 1 {
+			return nil, errNotWeighted
+		}
+		return l.Allow(ctx, key)
+	}
+
+	start := time.Now()
+	res, err := wl.AllowN(ctx, key, n)
+	l.record(ctx, res, err, time.Since(start))
+	return res, err
+}
+
+// WaitN waits for n permits through the wrapped limiter and records the time
+// spent. Limiters that are not a leaky_bucket.WeightedLimiter only support n == 1.
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	wl, ok := leaky_bucket.As[leaky_bucket.WeightedLimiter](l.next)
+	if !ok {
+		if n != 1 {
+			return errNotWeighted
+		}
+		return l.Wait(ctx, key)
+	}
+
+	start := time.Now()
+	err := wl.WaitN(ctx, key, n)
+	l.recordWait(ctx, err, time.Since(start))
+	return err
+}
+
+// Wait waits through the wrapped limiter and records the time spent
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	start := time.Now()
+	err := l.next.Wait(ctx, key)
+	l.recordWait(ctx, err, time.Since(start))
+	return err
+}
+
+// WaitTimeout waits through the wrapped limiter and records the time spent
+func (l *Limiter) WaitTimeout(ctx context.Context, key string, timeout time.Duration) error {
+	start := time.Now()
+	err := l.next.WaitTimeout(ctx, key, timeout)
+	l.recordWait(ctx, err, time.Since(start))
+	return err
+}
+
+// record counts the outcome of a check
+func (l *Limiter) record(ctx context.Context, res *leaky_bucket.Result, err error, elapsed time.Duration) {
+	m := l.metrics
+	route := leaky_bucket.RouteFromContext(ctx)
+	shadow := leaky_bucket.ShadowFromContext(ctx)
+
+	if err != nil {
+		class := leaky_bucket.ErrorClass(err)
+		m.errors.WithLabelValues(l.name, class).Inc()
+		// Mirrors the middlewares, which only deny configuration errors
+		// without a Result. Shadow checks let every request through anyway.
+		if !shadow && ((res != nil && res.Allowed) || (res == nil && class != "config")) {
+			m.failOpen.WithLabelValues(l.name, route).Inc()
+		}
+	}
+	if res == nil {
+		return
+	}
+
+	decision := "denied"
+	if res.Allowed {
+		decision = "allowed"
+	}
+	source := res.Source.String()
+	decisions := m.decisions
+	if shadow {
+		decisions = m.shadow
+	}
+	decisions.WithLabelValues(l.name, route, decision, source).Inc()
+	m.checks.WithLabelValues(l.name, source).Observe(elapsed.Seconds())
+}
+
+// recordWait observes the duration of a wait
+func (l *Limiter) recordWait(ctx context.Context, err error, elapsed time.Duration) {
+	outcome := "acquired"
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		outcome = "cancelled"
+	case err != nil:
+		outcome = "error"
+		l.metrics.errors.WithLabelValues(l.name, leaky_bucket.ErrorClass(err)).Inc()
+	}
+	l.metrics.waits.WithLabelValues(l.name, leaky_bucket.RouteFromContext(ctx), outcome).Observe(elapsed.Seconds())
+}
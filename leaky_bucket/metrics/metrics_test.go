@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	leaky_bucket "github.com/alibazlamit/leaky_bucket_redis/v2/leaky_bucket"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+)
+
+func createTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func newMetrics(t *testing.T) (*Metrics, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return m, reg
+}
+
+func TestLimiter_Decisions(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, _ := newMetrics(t)
+	limiter := m.Wrap(leaky_bucket.New(client, 1.0), "api")
+	ctx := context.Background()
+
+	limiter.Allow(ctx, "user:1")
+	limiter.Allow(ctx, "user:1")
+
+	if v := testutil.ToFloat64(m.decisions.WithLabelValues("api", "", "allowed", "redis")); v != 1 {
+		t.Errorf("Expected 1 allowed decision, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.decisions.WithLabelValues("api", "", "denied", "redis")); v != 1 {
+		t.Errorf("Expected 1 denied decision, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.checks); n != 1 {
+		t.Errorf("Expected 1 latency series, got %d", n)
+	}
+}
+
+func TestLimiter_FailOpen(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	m, _ := newMetrics(t)
+	limiter := m.Wrap(leaky_bucket.New(client, 1.0), "api")
+
+	if _, err := limiter.Allow(context.Background(), "user:1"); err == nil {
+		t.Fatal("Expected the backend error to be passed on")
+	}
+
+	if v := testutil.ToFloat64(m.errors.WithLabelValues("api", "backend_unavailable")); v != 1 {
+		t.Errorf("Expected 1 backend error, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.failOpen.WithLabelValues("api", "")); v != 1 {
+		t.Errorf("Expected 1 fail-open event, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.decisions.WithLabelValues("api", "", "allowed", "failure-policy")); v != 1 {
+		t.Errorf("Expected 1 decision of the failure policy, got %v", v)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, _ := newMetrics(t)
+	limiter := m.Wrap(leaky_bucket.New(client, 20.0), "worker")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(ctx, "jobs"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	var metric dto.Metric
+	m.waits.WithLabelValues("worker", "", "acquired").(prometheus.Metric).Write(&metric)
+	if n := metric.GetHistogram().GetSampleCount(); n != 2 {
+		t.Errorf("Expected 2 acquired waits, got %d", n)
+	}
+}
+
+func TestLimiter_OptionalInterfaces(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, _ := newMetrics(t)
+	lb := leaky_bucket.New(client, 10.0, leaky_bucket.WithBurst(5))
+	limiter := m.Wrap(lb, "api")
+	ctx := context.Background()
+
+	// Weighted checks are recorded, e.g. by leaky_bucket.NewReader
+	var _ leaky_bucket.WeightedLimiter = limiter
+	if res, err := limiter.AllowN(ctx, "bytes", 3); err != nil || res.Remaining != 2 {
+		t.Fatalf("Expected 3 of 5 permits to be taken, got %+v, %v", res, err)
+	}
+	if v := testutil.ToFloat64(m.decisions.WithLabelValues("api", "", "allowed", "redis")); v != 1 {
+		t.Errorf("Expected the weighted decision to be recorded, got %v", v)
+	}
+
+	// The transport pauses the wrapped bucket on upstream 429s
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodGet, srv.URL, nil)
+	req.RequestURI = ""
+	resp, err := leaky_bucket.NewTransport(limiter, leaky_bucket.ExtractHost).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if res, _ := lb.Allow(ctx, leaky_bucket.ExtractHost(req)); res.Allowed {
+		t.Error("Expected the transport to pause the bucket behind the wrapper")
+	}
+}
+
+func TestMiddleware_RouteLabel(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, _ := newMetrics(t)
+	limiter := m.Wrap(leaky_bucket.New(client, 10.0, leaky_bucket.WithBurst(5)), "api")
+	mw := leaky_bucket.Middleware(limiter, leaky_bucket.ExtractIP, leaky_bucket.WithRouteLabel())
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/3"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+
+	if v := testutil.ToFloat64(m.decisions.WithLabelValues("api", "GET /users/{id}", "allowed", "redis")); v != 3 {
+		t.Errorf("Expected 3 decisions labeled with the route pattern, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.decisions); n != 1 {
+		t.Errorf("Expected the paths to share 1 series, got %d", n)
+	}
+}
+
+func TestMiddleware_Shadow(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, _ := newMetrics(t)
+	limiter := m.Wrap(leaky_bucket.New(client, 1.0), "api")
+	handler := leaky_bucket.Middleware(limiter, leaky_bucket.ExtractIP, leaky_bucket.WithShadow())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if v := testutil.ToFloat64(m.shadow.WithLabelValues("api", "", "denied", "redis")); v != 1 {
+		t.Errorf("Expected 1 shadow denial, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.decisions); n != 0 {
+		t.Errorf("Expected no enforced decisions, got %d series", n)
+	}
+}
+
+func TestMetrics_BreakerState(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	m, reg := newMetrics(t)
+	m.Wrap(leaky_bucket.New(client, 10.0, leaky_bucket.WithCircuitBreaker(leaky_bucket.BreakerConfig{})), "api")
+
+	expected := `
+		# HELP leaky_bucket_breaker_state State of the circuit breaker in front of Redis: 0 closed, 1 open, 2 half-open.
+		# TYPE leaky_bucket_breaker_state gauge
+		leaky_bucket_breaker_state{limiter="api"} 0
+	`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "leaky_bucket_breaker_state"); err != nil {
+		t.Errorf("Unexpected breaker metrics: %v", err)
+	}
+}
+
+func TestNew_DuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := New(reg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := New(reg); err == nil {
+		t.Error("Expected registering the metrics twice to fail")
+	}
+}
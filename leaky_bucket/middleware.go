@@ -1,9 +1,12 @@
 package leaky_bucket_redis
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // KeyExtractor defines a function to extract a rate limiting key from a request
@@ -34,15 +37,44 @@ type MiddlewareOption func(*middlewareConfig)
 type middlewareConfig struct {
 	errorHandler func(w http.ResponseWriter, r *http.Request, res *Result)
 	onLimit      func(r *http.Request, res *Result)
+	ginDenied    GinDeniedHandler
+	echoDenied   EchoDeniedHandler
+	skipper      func(r *http.Request) bool
+	allowlist    []Rule
+	denylist     []Rule
+	bypassHeader string
+	bypassSecret []byte
+	bypassMaxAge time.Duration
+	maxDelay     time.Duration
+	priority     func(r *http.Request) Priority
+	routeLabel   bool
+
+	shadow        bool
+	shadowLimiter Limiter
+	onShadowLimit func(r *http.Request, res *Result)
 }
 
-// WithErrorHandler sets a custom function to handle rate-limited requests
+// WithErrorHandler sets a custom function to handle rate-limited requests.
+// It is honored by Middleware, GinMiddleware and EchoMiddleware; the
+// framework adapters prefer their native denied handlers when both are set.
 func WithErrorHandler(h func(w http.ResponseWriter, r *http.Request, res *Result)) MiddlewareOption {
 	return func(c *middlewareConfig) {
 		c.errorHandler = h
 	}
 }
 
+// WithShaping switches the middleware from rejecting to delaying requests.
+// A request that can be served within maxDelay reserves its slot and is held
+// until then, respecting cancellation of the request context; the time spent
+// queued is reported in the X-RateLimit-Delay header. Requests that would wait
+// longer are rejected as usual. The limiter must implement Reserver, otherwise
+// the option has no effect.
+func WithShaping(maxDelay time.Duration) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.maxDelay = maxDelay
+	}
+}
+
 // WithOnLimit sets a callback that is triggered whenever a request is rate limited
 func WithOnLimit(cb func(r *http.Request, res *Result)) MiddlewareOption {
 	return func(c *middlewareConfig) {
@@ -68,41 +100,189 @@ func ExtractCookie(name string) KeyExtractor {
 	}
 }
 
-// Middleware returns a standard http.Handler middleware
-func Middleware(limiter Limiter, extractor KeyExtractor, opts ...MiddlewareOption) func(http.Handler) http.Handler {
-	config := &middlewareConfig{
-		errorHandler: func(w http.ResponseWriter, r *http.Request, res *Result) {
-			w.Header().Set("Retry-After", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-		},
+// setRateLimitHeaders writes the standard rate limit headers for res to h.
+// Retry-After is only set when the request was denied.
+func setRateLimitHeaders(h http.Header, res *Result) {
+	h.Set("X-RateLimit-Limit", strconv.FormatFloat(res.Limit, 'f', -1, 64))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
 	}
+}
 
+// newMiddlewareConfig applies opts on top of the defaults shared by all adapters
+func newMiddlewareConfig(opts []MiddlewareOption) *middlewareConfig {
+	config := &middlewareConfig{}
 	for _, opt := range opts {
 		opt(config)
 	}
+	return config
+}
+
+// decision is the outcome of evaluating a request against the middleware configuration
+type decision int
+
+const (
+	decisionPass   decision = iota // serve the request without consulting the limiter
+	decisionAllow                  // serve the request, rate limit headers are set
+	decisionLimit                  // reject the request with the denied handler
+	decisionForbid                 // reject the request because it matched the denylist
+	decisionCancel                 // the request context ended while the request was queued
+	decisionError                  // the limiter is misconfigured, see failsOpen
+)
+
+// failsOpen reports whether a request is served when the limiter returned err
+// without a Result. Configuration errors are answered with a 500 instead, so
+// that a broken limiter does not silently turn into no limit at all.
+func failsOpen(err error) bool {
+	return !errors.Is(err, ErrInvalidRate) && !errors.Is(err, ErrCostExceedsBurst)
+}
+
+// evaluate applies the bypass rules and the limiter to r. Rate limit headers
+// are written to h and the onLimit callback is triggered for limited requests,
+// so that every adapter only has to act on the returned decision.
+func (c *middlewareConfig) evaluate(r *http.Request, h http.Header, limiter Limiter, extractor KeyExtractor) (decision, *Result) {
+	if c.skipper != nil && c.skipper(r) {
+		return decisionPass, nil
+	}
+
+	key := extractor(r)
+	if matchAny(c.denylist, r, key) {
+		return decisionForbid, nil
+	}
+	if matchAny(c.allowlist, r, key) || c.bypass(r) {
+		return decisionPass, nil
+	}
+
+	ctx := r.Context()
+	if c.shadowLimiter != nil {
+		c.checkShadow(ctx, r, key)
+	}
+
+	if c.shadow {
+		ctx = ContextWithShadow(ctx)
+	}
+
+	res, err := c.check(ctx, limiter, key)
+	if c.shadow {
+		if res != nil && !res.Allowed && c.onLimit != nil {
+			c.onLimit(r, res)
+		}
+		return decisionPass, nil
+	}
+	if res == nil {
+		if failsOpen(err) {
+			return decisionPass, nil
+		}
+		return decisionError, nil
+	}
+
+	setRateLimitHeaders(h, res)
+
+	if !res.Allowed {
+		if c.onLimit != nil {
+			c.onLimit(r, res)
+		}
+		return decisionLimit, res
+	}
+
+	if res.WaitTime > 0 {
+		h.Set("X-RateLimit-Delay", strconv.FormatFloat(res.WaitTime.Seconds(), 'f', 3, 64))
+		if !sleepContext(ctx, res.WaitTime) {
+			releaseSlot(ctx, limiter, key, res)
+			return decisionCancel, res
+		}
+	}
+	return decisionAllow, res
+}
+
+// check asks limiter about key. In shaping mode a slot is reserved when the
+// limiter supports it, so that the request can be delayed instead of rejected.
+func (c *middlewareConfig) check(ctx context.Context, limiter Limiter, key string) (*Result, error) {
+	if c.maxDelay > 0 && !c.shadow {
+		if rsv, ok := As[Reserver](limiter); ok {
+			return rsv.Reserve(ctx, key, c.maxDelay)
+		}
+	}
+	return limiter.Allow(ctx, key)
+}
+
+// releaseSlot hands the permit reserved for res back to limiter when the
+// request gave up before its slot was due. Only slots held in Redis are released.
+func releaseSlot(ctx context.Context, limiter Limiter, key string, res *Result) {
+	if res.Source != SourceRedis {
+		return
+	}
+	if rel, ok := As[Releaser](limiter); ok {
+		rel.Release(context.WithoutCancel(ctx), key, 1)
+	}
+}
+
+// sleepContext waits for d and reports whether it elapsed before ctx was done
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// defaultErrorHandler answers rate-limited requests with a plain text 429
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, res *Result) {
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// withContext attaches the priority and the route of r to its context, so
+// that both the limiter and the handlers after the middleware see them
+func (c *middlewareConfig) withContext(r *http.Request, route string) *http.Request {
+	ctx := r.Context()
+	if c.priority != nil {
+		ctx = ContextWithPriority(ctx, c.priority(r))
+	}
+	if c.routeLabel && RouteFromContext(ctx) == "" {
+		ctx = ContextWithRoute(ctx, route)
+	}
+	if ctx == r.Context() {
+		return r
+	}
+	return r.WithContext(ctx)
+}
+
+// serve evaluates r and either rejects it or passes it on to next
+func (c *middlewareConfig) serve(w http.ResponseWriter, r *http.Request, next http.Handler, limiter Limiter, extractor KeyExtractor) {
+	var route string
+	if c.routeLabel {
+		route = routePattern(r, next)
+	}
+	r = c.withContext(r, route)
+	switch d, res := c.evaluate(r, w.Header(), limiter, extractor); d {
+	case decisionForbid:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case decisionLimit:
+		c.errorHandler(w, r, res)
+	case decisionCancel:
+		http.Error(w, "Request cancelled while queued", http.StatusServiceUnavailable)
+	case decisionError:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Middleware returns a standard http.Handler middleware
+func Middleware(limiter Limiter, extractor KeyExtractor, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	config := newMiddlewareConfig(opts)
+	if config.errorHandler == nil {
+		config.errorHandler = defaultErrorHandler
+	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := extractor(r)
-			res, err := limiter.Allow(r.Context(), key)
-			
-			if err != nil {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(res.Limit, 'f', -1, 64))
-			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
-			
-			if !res.Allowed {
-				if config.onLimit != nil {
-					config.onLimit(r, res)
-				}
-				config.errorHandler(w, r, res)
-				return
-			}
-
-			next.ServeHTTP(w, r)
+			config.serve(w, r, next, limiter, extractor)
 		})
 	}
 }
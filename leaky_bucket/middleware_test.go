@@ -1,9 +1,11 @@
 package leaky_bucket_redis
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestExtractIP(t *testing.T) {
@@ -126,3 +128,121 @@ func TestMiddleware_FailOpen(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", rec.Code)
 	}
 }
+
+func TestMiddleware_FailClosed(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	lb := New(client, 10.0, WithFailurePolicy(FailClosed))
+	mw := Middleware(lb, func(r *http.Request) string { return "fail" })
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_ConfigError(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0)
+	mw := Middleware(lb, func(r *http.Request) string { return "misconfigured" })
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Shaping(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 10.0) // 100ms interval
+	mw := Middleware(lb, func(r *http.Request) string { return "shaped" }, WithShaping(150*time.Millisecond))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec1 := httptest.NewRecorder()
+	handler.ServeHTTP(rec1, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec1.Code != http.StatusOK || rec1.Header().Get("X-RateLimit-Delay") != "" {
+		t.Errorf("Expected undelayed 200, got %d with delay %q", rec1.Code, rec1.Header().Get("X-RateLimit-Delay"))
+	}
+
+	// Second request is queued for ~100ms instead of being rejected
+	start := time.Now()
+	rec2 := httptest.NewRecorder()
+	handler.ServeHTTP(rec2, httptest.NewRequest(http.MethodGet, "/", nil))
+	elapsed := time.Since(start)
+
+	if rec2.Code != http.StatusOK {
+		t.Errorf("Expected delayed request to succeed, got %d", rec2.Code)
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("Expected request to be held, returned after %v", elapsed)
+	}
+	if rec2.Header().Get("X-RateLimit-Delay") == "" {
+		t.Error("Expected X-RateLimit-Delay header")
+	}
+
+	// Two concurrent requests would wait ~100ms and ~200ms: only the first fits
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes <- rec.Code
+		}()
+	}
+	got := map[int]int{}
+	for i := 0; i < 2; i++ {
+		got[<-codes]++
+	}
+	if got[http.StatusOK] != 1 || got[http.StatusTooManyRequests] != 1 {
+		t.Errorf("Expected one delayed 200 and one 429, got %v", got)
+	}
+}
+
+func TestMiddleware_ShapingCancelled(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 1.0)
+	var served bool
+	mw := Middleware(lb, func(r *http.Request) string { return "shaped_cancel" }, WithShaping(time.Second))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	lb.Allow(context.Background(), "shaped_cancel")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	if served {
+		t.Error("Expected cancelled request not to reach the handler")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+
+	// The slot of the cancelled request was handed back
+	res, _ := lb.Reserve(context.Background(), "shaped_cancel", time.Second)
+	if !res.Allowed {
+		t.Errorf("Expected the released slot to be available, got wait %v", res.WaitTime)
+	}
+}
@@ -0,0 +1,98 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Priority classifies requests for admission when capacity is scarce.
+// Lower priorities can be configured to leave part of the burst untouched
+// so that higher priorities still get through when a bucket runs low.
+type Priority int
+
+const (
+	// PriorityDefault is the priority of requests that carry no explicit priority.
+	PriorityDefault Priority = iota
+	// PriorityCritical requests may always use the whole burst.
+	PriorityCritical
+	// PrioritySheddable requests are the first to be rejected under pressure.
+	PrioritySheddable
+)
+
+// String returns the lower-case name of the priority
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PrioritySheddable:
+		return "sheddable"
+	default:
+		return "default"
+	}
+}
+
+// ParsePriority parses a priority name as returned by Priority.String.
+// It reports false for unknown names.
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return PriorityCritical, true
+	case "default":
+		return PriorityDefault, true
+	case "sheddable":
+		return PrioritySheddable, true
+	}
+	return PriorityDefault, false
+}
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a copy of ctx carrying priority p.
+// Allow and Reserve read the priority of a request from its context.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority carried by ctx, or PriorityDefault
+func PriorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// WithPriorityReserve makes requests of priority p leave fraction of the burst
+// untouched: they are rejected once fewer than burst*fraction requests
+// (rounded down) would remain after admitting them. Priorities without a
+// reserve, PriorityCritical by default, can use the whole burst.
+func WithPriorityReserve(p Priority, fraction float64) Option {
+	return func(lb *LeakyBucketRedis) {
+		if lb.reserves == nil {
+			lb.reserves = make(map[Priority]float64)
+		}
+		lb.reserves[p] = min(max(fraction, 0), 1)
+	}
+}
+
+// reserved returns the number of requests that p must leave in the bucket
+func (lb *LeakyBucketRedis) reserved(p Priority) int {
+	return int(float64(lb.burst) * lb.reserves[p])
+}
+
+// WithPriority sets a function that derives the priority of each request.
+// The priority is attached to the request context before the limiter is called.
+func WithPriority(fn func(r *http.Request) Priority) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.priority = fn
+	}
+}
+
+// PriorityHeader returns a priority function for WithPriority that reads the
+// priority name from the named header. Missing or unknown values map to PriorityDefault.
+// Clients can set any header, so only use it behind a trusted hop, such as a
+// gateway, that sets or strips the header on every request.
+func PriorityHeader(name string) func(r *http.Request) Priority {
+	return func(r *http.Request) Priority {
+		p, _ := ParsePriority(r.Header.Get(name))
+		return p
+	}
+}
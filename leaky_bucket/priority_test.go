@@ -0,0 +1,143 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{PriorityCritical, PriorityDefault, PrioritySheddable} {
+		got, ok := ParsePriority(p.String())
+		if !ok || got != p {
+			t.Errorf("ParsePriority(%q) = %v, %v", p.String(), got, ok)
+		}
+	}
+	if _, ok := ParsePriority("urgent"); ok {
+		t.Error("Expected unknown priority to be rejected")
+	}
+}
+
+func TestLeakyBucketRedis_PriorityReserve(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	// Effectively no refill during the test
+	lb := New(client, 0.01, WithBurst(10),
+		WithPriorityReserve(PriorityDefault, 0.2),
+		WithPriorityReserve(PrioritySheddable, 0.5),
+	)
+
+	admit := func(key string, p Priority) int {
+		ctx := ContextWithPriority(context.Background(), p)
+		n := 0
+		for i := 0; i < 20; i++ {
+			res, err := lb.Allow(ctx, key)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if res.Allowed {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := admit("prio_sheddable", PrioritySheddable); n != 5 {
+		t.Errorf("Expected sheddable traffic to use half the burst, got %d", n)
+	}
+	if n := admit("prio_default", PriorityDefault); n != 8 {
+		t.Errorf("Expected default traffic to leave 20%% of the burst, got %d", n)
+	}
+	if n := admit("prio_critical", PriorityCritical); n != 10 {
+		t.Errorf("Expected critical traffic to use the whole burst, got %d", n)
+	}
+
+	// Once background traffic hit its reserve, critical requests still get through
+	ctx := context.Background()
+	admit("prio_shared", PrioritySheddable)
+	if res, _ := lb.Allow(ContextWithPriority(ctx, PrioritySheddable), "prio_shared"); res.Allowed {
+		t.Error("Expected sheddable request to be rejected at its reserve")
+	}
+	if res, _ := lb.Allow(ContextWithPriority(ctx, PriorityCritical), "prio_shared"); !res.Allowed {
+		t.Error("Expected critical request to use the reserved capacity")
+	}
+}
+
+func TestMiddleware_PriorityHeader(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(2), WithPriorityReserve(PriorityDefault, 0.5))
+	mw := Middleware(lb, func(r *http.Request) string { return "prio_mw" }, WithPriority(PriorityHeader("X-Priority")))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(priority string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Priority", priority)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(""); code != http.StatusOK {
+		t.Errorf("Expected first default request to pass, got %d", code)
+	}
+	if code := do(""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected default request to be held back by the reserve, got %d", code)
+	}
+	if code := do("critical"); code != http.StatusOK {
+		t.Errorf("Expected critical request to pass, got %d", code)
+	}
+}
+
+func TestMiddleware_PriorityInHandler(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var got Priority
+	mw := Middleware(New(client, 10.0), ExtractIP, WithPriority(PriorityHeader("X-Priority")))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PriorityFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Priority", "critical")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != PriorityCritical {
+		t.Errorf("Expected the handler to see PriorityCritical, got %v", got)
+	}
+}
+
+func TestRouteMiddleware_Priority(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 0.01, WithBurst(2), WithPriorityReserve(PriorityDefault, 0.5))
+	mw := RouteMiddleware([]Route{
+		{Pattern: "POST /checkout", Limiter: lb, Priority: PriorityCritical},
+		{Pattern: "GET /feed", Limiter: lb},
+	}, Route{})
+
+	var seen Priority
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = PriorityFromContext(r.Context())
+	}))
+
+	codes := []int{}
+	for _, target := range []string{"/checkout", "/checkout"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Errorf("Expected critical route to use the whole burst, got %v", codes)
+	}
+	if seen != PriorityCritical {
+		t.Errorf("Expected handler to see priority critical, got %v", seen)
+	}
+}
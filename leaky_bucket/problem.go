@@ -0,0 +1,57 @@
+package leaky_bucket_redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details responses
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 problem details object for a rate-limited request.
+type ProblemDetails struct {
+	Type     string `json:"type"`               // Type is a URI identifying the problem type.
+	Title    string `json:"title"`              // Title is a short, human-readable summary of the problem type.
+	Status   int    `json:"status"`             // Status is the HTTP status code.
+	Detail   string `json:"detail,omitempty"`   // Detail explains this occurrence of the problem.
+	Instance string `json:"instance,omitempty"` // Instance identifies the request that was limited.
+
+	// RetryAfter is an extension member holding the wait time in seconds.
+	RetryAfter float64 `json:"retry_after"`
+	// Limit is an extension member holding the configured requests per second.
+	Limit float64 `json:"limit"`
+}
+
+// NewProblemDetails builds the problem details describing a rate-limited request
+func NewProblemDetails(r *http.Request, res *Result) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       "about:blank",
+		Title:      http.StatusText(http.StatusTooManyRequests),
+		Status:     http.StatusTooManyRequests,
+		Detail:     fmt.Sprintf("Rate limit exceeded, retry after %.3fs", res.WaitTime.Seconds()),
+		Instance:   r.URL.RequestURI(),
+		RetryAfter: math.Round(res.WaitTime.Seconds()*1000) / 1000,
+		Limit:      res.Limit,
+	}
+}
+
+// WriteProblemDetails writes an application/problem+json response for a rate-limited request.
+// It has the signature expected by WithErrorHandler.
+func WriteProblemDetails(w http.ResponseWriter, r *http.Request, res *Result) {
+	body, err := json.Marshal(NewProblemDetails(r, res))
+	if err != nil {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
+
+// WithProblemDetails makes every adapter answer rate-limited requests with
+// RFC 9457 problem details. It is shorthand for WithErrorHandler(WriteProblemDetails).
+func WithProblemDetails() MiddlewareOption {
+	return WithErrorHandler(WriteProblemDetails)
+}
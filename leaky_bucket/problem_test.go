@@ -0,0 +1,149 @@
+package leaky_bucket_redis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo/v4"
+)
+
+// adapterHandlers wraps an OK handler with each middleware adapter using the same options
+func adapterHandlers(t *testing.T, opts ...MiddlewareOption) map[string]http.Handler {
+	t.Helper()
+	extractor := func(r *http.Request) string { return "parity" }
+
+	std := Middleware(New(createTestClient(t), 10.0), extractor, opts...)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinMiddleware(New(createTestClient(t), 10.0), extractor, opts...))
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	e := echo.New()
+	e.Use(EchoMiddleware(New(createTestClient(t), 10.0), extractor, opts...))
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	return map[string]http.Handler{"http": std, "gin": router, "echo": e}
+}
+
+// limitedResponse sends two requests through h and returns the second, rate-limited response
+func limitedResponse(h http.Handler) *httptest.ResponseRecorder {
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestAdapters_ErrorHandlerParity(t *testing.T) {
+	var calls int
+	handlers := adapterHandlers(t, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, res *Result) {
+		calls++
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte("pay up"))
+	}))
+
+	for name, h := range handlers {
+		rec := limitedResponse(h)
+		if rec.Code != http.StatusPaymentRequired {
+			t.Errorf("%s: Expected custom error code 402, got %d", name, rec.Code)
+		}
+		if body := rec.Body.String(); body != "pay up" {
+			t.Errorf("%s: Expected custom body, got %q", name, body)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: Expected Retry-After header", name)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "10" {
+			t.Errorf("%s: Expected X-RateLimit-Limit 10, got %q", name, rec.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+
+	if calls != len(handlers) {
+		t.Errorf("Expected error handler to be called %d times, got %d", len(handlers), calls)
+	}
+}
+
+func TestAdapters_ProblemDetailsParity(t *testing.T) {
+	var bodies []ProblemDetails
+	for name, h := range adapterHandlers(t, WithProblemDetails()) {
+		rec := limitedResponse(h)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: Expected status 429, got %d", name, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Errorf("%s: Expected Content-Type %s, got %s", name, ProblemContentType, ct)
+		}
+
+		var p ProblemDetails
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: Invalid problem details body: %v", name, err)
+		}
+		if p.Status != http.StatusTooManyRequests || p.Instance != "/" || p.Limit != 10 {
+			t.Errorf("%s: Unexpected problem details %+v", name, p)
+		}
+		if p.RetryAfter <= 0 {
+			t.Errorf("%s: Expected positive retry_after, got %v", name, p.RetryAfter)
+		}
+		p.RetryAfter, p.Detail = 0, ""
+		bodies = append(bodies, p)
+	}
+
+	for i := 1; i < len(bodies); i++ {
+		if bodies[i] != bodies[0] {
+			t.Errorf("Expected identical problem details across adapters, got %+v and %+v", bodies[0], bodies[i])
+		}
+	}
+}
+
+func TestAdapters_DefaultBodyParity(t *testing.T) {
+	handlers := adapterHandlers(t)
+
+	ginRec := limitedResponse(handlers["gin"])
+	echoRec := limitedResponse(handlers["echo"])
+
+	var ginBody, echoBody map[string]string
+	if err := json.Unmarshal(ginRec.Body.Bytes(), &ginBody); err != nil {
+		t.Fatalf("Invalid gin body: %v", err)
+	}
+	if err := json.Unmarshal(echoRec.Body.Bytes(), &echoBody); err != nil {
+		t.Fatalf("Invalid echo body: %v", err)
+	}
+	if ginBody["error"] != echoBody["error"] || ginBody["error"] != "Rate limit exceeded" {
+		t.Errorf("Expected matching default error bodies, got %v and %v", ginBody, echoBody)
+	}
+
+	stdRec := limitedResponse(handlers["http"])
+	if stdRec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", stdRec.Code)
+	}
+}
+
+func TestNativeDeniedHandlers(t *testing.T) {
+	fallback := WithErrorHandler(func(w http.ResponseWriter, r *http.Request, res *Result) {
+		t.Error("WithErrorHandler should not be used when a native handler is set")
+	})
+
+	ginHandlers := adapterHandlers(t, fallback, WithGinDeniedHandler(func(c *gin.Context, res *Result) {
+		c.Data(http.StatusServiceUnavailable, ProblemContentType, []byte(`{"status":503}`))
+	}))
+	if rec := limitedResponse(ginHandlers["gin"]); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected gin native handler status 503, got %d", rec.Code)
+	}
+
+	echoHandlers := adapterHandlers(t, fallback, WithEchoDeniedHandler(func(c echo.Context, res *Result) error {
+		return c.Blob(http.StatusServiceUnavailable, ProblemContentType, []byte(`{"status":503}`))
+	}))
+	if rec := limitedResponse(echoHandlers["echo"]); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected echo native handler status 503, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,236 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrQueueFull is returned by Wait in queue mode when the queue for the key has reached its maximum length
+var ErrQueueFull = errors.New("wait queue is full")
+
+const (
+	// queueTicketTTL is how long a ticket survives without its waiter polling,
+	// so that waiters of crashed instances do not block the queue
+	queueTicketTTL = 10 * time.Second
+	// queuePollInterval bounds how long a waiter sleeps between polls
+	queuePollInterval = queueTicketTTL / 4
+)
+
+// WithQueue makes Wait take a ticket in a queue shared by all instances and
+// grants permits strictly in ticket order. At most maxLen waiters may queue
+// per key (0 means no limit); further calls to Wait fail with ErrQueueFull.
+//
+// The queue is kept next to the bucket in keys suffixed with ":queue". With
+// Redis Cluster, use keys with a hash tag such as "{user:42}" so that they
+// all live in the same slot.
+func WithQueue(maxLen int) Option {
+	return func(lb *LeakyBucketRedis) {
+		lb.queued = true
+		lb.maxQueue = max(maxLen, 0)
+	}
+}
+
+// queueKeys returns the keys of the queue for key: the tickets in order,
+// their expiry times and the ticket counter
+func queueKeys(key string) []string {
+	return []string{key + ":queue", key + ":queue:seen", key + ":queue:seq"}
+}
+
+// queuePruneScript removes the tickets at the head of the queue whose waiter
+// stopped polling. KEYS[1]: queue, KEYS[2]: expiry times
+const queuePruneScript = `
+	local function prune(queue, seen, now, own)
+		while true do
+			local head = redis.call('ZRANGE', queue, 0, 0)[1]
+			if not head or head == own then
+				return
+			end
+			local expires = tonumber(redis.call('HGET', seen, head))
+			if expires and expires >= now then
+				return
+			end
+			redis.call('ZREM', queue, head)
+			redis.call('HDEL', seen, head)
+		end
+	end
+`
+
+// waitQueued takes a ticket and polls until it reaches the head of the queue
+// and n permits are granted
+func (lb *LeakyBucketRedis) waitQueued(ctx context.Context, key string, n int) error {
+	if lb.err != nil {
+		return lb.err
+	}
+	if key == "" {
+		return ErrInvalidKey
+	}
+	n = max(n, 1)
+	if n > lb.burst {
+		return ErrCostExceedsBurst
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ticket, err := lb.enqueue(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			return err
+		}
+		return lb.waitFailure(err)
+	}
+
+	var timer *time.Timer
+	for {
+		res, lost, err := lb.poll(ctx, key, ticket, n)
+		if err != nil {
+			// Leave the queue, or the ticket holds up the waiters behind it until it expires
+			lb.dequeue(context.WithoutCancel(ctx), key, ticket)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return lb.waitFailure(err)
+		}
+		if lost {
+			// The ticket expired, e.g. after a long pause: queue again at the back.
+			// The old ticket is gone, so there is nothing to leave if this fails.
+			if ticket, err = lb.enqueue(ctx, key); err != nil {
+				if errors.Is(err, ErrQueueFull) {
+					return err
+				}
+				return lb.waitFailure(err)
+			}
+			continue
+		}
+		if res.Allowed {
+			return nil
+		}
+
+		wait := min(res.WaitTime, queuePollInterval)
+		if timer == nil {
+			timer = time.NewTimer(wait)
+			defer timer.Stop()
+		} else {
+			timer.Reset(wait)
+		}
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			lb.dequeue(context.WithoutCancel(ctx), key, ticket)
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueue takes the next ticket in the queue for key
+func (lb *LeakyBucketRedis) enqueue(ctx context.Context, key string) (string, error) {
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+
+	// ARGV[1]: now, ARGV[2]: ticket ttl, ARGV[3]: max queue length (0 for none)
+	script := queuePruneScript + `
+		local now = tonumber(ARGV[1])
+		local ttl = tonumber(ARGV[2])
+		local max_len = tonumber(ARGV[3])
+
+		prune(KEYS[1], KEYS[2], now, nil)
+		if max_len > 0 and redis.call('ZCARD', KEYS[1]) >= max_len then
+			return false
+		end
+
+		local ticket = redis.call('INCR', KEYS[3])
+		redis.call('ZADD', KEYS[1], ticket, tostring(ticket))
+		redis.call('HSET', KEYS[2], tostring(ticket), now + ttl)
+		for _, k in ipairs(KEYS) do
+			redis.call('EXPIRE', k, math.ceil(ttl) * 2)
+		end
+		return tostring(ticket)
+	`
+
+	ticket, err := redisCall(ctx, lb, func(ctx context.Context) (string, error) {
+		return lb.client.Eval(ctx, script, queueKeys(key), nowFloat, queueTicketTTL.Seconds(), lb.maxQueue).Text()
+	})
+	if errors.Is(err, redis.Nil) {
+		return "", ErrQueueFull
+	}
+	return ticket, err
+}
+
+// poll refreshes ticket and, if it is at the head of the queue, tries to take
+// n permits. It reports lost if the ticket is no longer queued. Until the
+// permits are granted, WaitTime estimates when to poll again.
+func (lb *LeakyBucketRedis) poll(ctx context.Context, key, ticket string, n int) (res *Result, lost bool, err error) {
+	nowFloat := float64(time.Now().UnixNano()) / 1e9
+
+	// KEYS[4]: bucket
+	// ARGV[1]: rate, ARGV[2]: burst, ARGV[3]: now, ARGV[4]: reserved, ARGV[5]: cost,
+	// ARGV[6]: ticket, ARGV[7]: ticket ttl
+	script := gcraScript + queuePruneScript + `
+		local rate = tonumber(ARGV[1])
+		local burst = tonumber(ARGV[2])
+		local now = tonumber(ARGV[3])
+		local ticket = ARGV[6]
+		local ttl = tonumber(ARGV[7])
+
+		prune(KEYS[1], KEYS[2], now, ticket)
+		local rank = redis.call('ZRANK', KEYS[1], ticket)
+		if not rank then
+			return {-1, "0", "0"}
+		end
+		redis.call('HSET', KEYS[2], ticket, now + ttl)
+		for i = 1, 3 do
+			redis.call('EXPIRE', KEYS[i], math.ceil(ttl) * 2)
+		end
+
+		if rank == 0 then
+			local res = gcra(KEYS[4], rate, burst, now, 0, tonumber(ARGV[4]), tonumber(ARGV[5]))
+			if res[1] == 1 then
+				redis.call('ZREM', KEYS[1], ticket)
+				redis.call('HDEL', KEYS[2], ticket)
+			end
+			return res
+		end
+
+		-- Estimate when the waiters ahead will have been served
+		local emission_interval = 1.0 / rate
+		local tat = tonumber(redis.call('GET', KEYS[4]) or now)
+		local head_wait = math.max(0, math.max(tat, now) + emission_interval - emission_interval * burst - now)
+		return {0, tostring(head_wait + rank * emission_interval), "0"}
+	`
+
+	keys := append(queueKeys(key), key)
+	reply, err := redisCall(ctx, lb, func(ctx context.Context) ([]interface{}, error) {
+		return lb.client.Eval(ctx, script, keys, lb.rate, lb.burst, nowFloat, lb.reserved(PriorityFromContext(ctx)), n, ticket, queueTicketTTL.Seconds()).Slice()
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(reply) > 0 && reply[0] == int64(-1) {
+		return nil, true, nil
+	}
+	res, err = parseResult(reply, lb.rate)
+	return res, false, err
+}
+
+// dequeue removes ticket from the queue for key
+func (lb *LeakyBucketRedis) dequeue(ctx context.Context, key, ticket string) error {
+	keys := queueKeys(key)
+	_, err := redisCall(ctx, lb, func(ctx context.Context) ([]redis.Cmder, error) {
+		return lb.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, keys[0], ticket)
+			pipe.HDel(ctx, keys[1], ticket)
+			return nil
+		})
+	})
+	return err
+}
+
+// QueueLen returns the number of waiters queued for key in queue mode
+func (lb *LeakyBucketRedis) QueueLen(ctx context.Context, key string) (int, error) {
+	n, err := redisCall(ctx, lb, func(ctx context.Context) (int64, error) {
+		return lb.client.ZCard(ctx, queueKeys(key)[0]).Result()
+	})
+	return int(n), err
+}
@@ -0,0 +1,152 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestQueue_FIFO(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 20.0, WithQueue(0))
+	ctx := context.Background()
+	key := "{queue}:fifo"
+	lb.Allow(ctx, key)
+
+	done := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			if err := lb.Wait(ctx, key); err == nil {
+				done <- i
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for want := 0; want < 4; want++ {
+		select {
+		case got := <-done:
+			if got != want {
+				t.Errorf("Expected waiter %d to be served next, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Waiter %d was never served", want)
+		}
+	}
+
+	if n, _ := lb.QueueLen(ctx, key); n != 0 {
+		t.Errorf("Expected empty queue, got %d waiters", n)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 1.0/3600.0, WithQueue(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := "{queue}:full"
+	lb.Allow(ctx, key)
+
+	for i := 0; i < 2; i++ {
+		go lb.Wait(ctx, key)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := lb.Wait(ctx, key); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestQueue_CancelLeaves(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 1.0/3600.0, WithQueue(1))
+	ctx := context.Background()
+	key := "{queue}:cancel"
+	lb.Allow(ctx, key)
+
+	if err := lb.WaitTimeout(ctx, key, 30*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error, got %v", err)
+	}
+	if n, _ := lb.QueueLen(ctx, key); n != 0 {
+		t.Errorf("Expected cancelled waiter to leave the queue, got %d waiters", n)
+	}
+}
+
+func TestQueue_PrunesStaleTickets(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 100.0, WithQueue(0))
+	ctx := context.Background()
+	key := "{queue}:stale"
+
+	// A ticket left behind by a crashed instance
+	keys := queueKeys(key)
+	client.ZAdd(ctx, keys[0], redis.Z{Score: 1, Member: "1"})
+	client.HSet(ctx, keys[1], "1", float64(time.Now().Add(-time.Second).Unix()))
+	client.Set(ctx, keys[2], 1, 0)
+
+	if err := lb.WaitTimeout(ctx, key, 200*time.Millisecond); err != nil {
+		t.Errorf("Expected stale ticket to be pruned, got %v", err)
+	}
+}
+
+// pollFailer fails the next polls of queued waiters
+type pollFailer struct {
+	fails atomic.Int32
+}
+
+func (h *pollFailer) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *pollFailer) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		args := cmd.Args()
+		if cmd.Name() == "eval" && strings.Contains(args[1].(string), "ZRANK") && h.fails.Add(-1) >= 0 {
+			cmd.SetErr(errors.New("poll failed"))
+			return cmd.Err()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *pollFailer) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestQueue_FailedPollLeaves(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	failer := &pollFailer{}
+	client.AddHook(failer)
+
+	lb := New(client, 20.0, WithQueue(0))
+	ctx := context.Background()
+	key := "{queue}:failed"
+	lb.Allow(ctx, key)
+
+	// FailOpen lets the waiter through, and its ticket must not stay at the head
+	failer.fails.Store(1)
+	if err := lb.Wait(ctx, key); err != nil {
+		t.Fatalf("Expected the failure policy to let the waiter through, got %v", err)
+	}
+	if n, _ := lb.QueueLen(ctx, key); n != 0 {
+		t.Errorf("Expected the failed waiter to leave the queue, got %d waiters", n)
+	}
+	if err := lb.WaitTimeout(ctx, key, 500*time.Millisecond); err != nil {
+		t.Errorf("Expected the next waiter to be served, got %v", err)
+	}
+}
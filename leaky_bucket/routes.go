@@ -0,0 +1,119 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+)
+
+// Route assigns its own limit to the requests matching a ServeMux pattern.
+type Route struct {
+	Pattern   string       // Pattern is a Go 1.22 ServeMux pattern such as "GET /users/{id}".
+	Limiter   Limiter      // Limiter enforces the route's limit. A nil Limiter leaves the route unlimited.
+	Extractor KeyExtractor // Extractor derives the client part of the key. A nil Extractor shares one bucket between all clients.
+	PerPath   bool         // PerPath keys buckets on the request path instead of the matched pattern.
+	Priority  Priority     // Priority is attached to the route's requests unless WithPriority is set.
+}
+
+type routeKey struct{}
+
+// ContextWithRoute returns a copy of ctx carrying the route pattern of the request.
+// Limiter decorators such as the metrics package use it as a label.
+func ContextWithRoute(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, routeKey{}, pattern)
+}
+
+// RouteFromContext returns the route pattern carried by ctx, or "" if there is none
+func RouteFromContext(ctx context.Context) string {
+	pattern, _ := ctx.Value(routeKey{}).(string)
+	return pattern
+}
+
+// WithRouteLabel attaches the route pattern of each request to the context
+// passed to the limiter, see ContextWithRoute. The pattern is the matched
+// http.ServeMux pattern (r.Pattern), Gin's FullPath, Echo's Path, or the
+// route of RouteMiddleware. When Middleware wraps a whole http.ServeMux, the
+// pattern the mux will match is used. Unlike keys, patterns are few and
+// bounded, so they are safe to use as metric labels.
+func WithRouteLabel() MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.routeLabel = true
+	}
+}
+
+// routePattern returns the ServeMux pattern of r: the one already matched, or
+// the one next will match if it is a ServeMux
+func routePattern(r *http.Request, next http.Handler) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	if mux, ok := next.(*http.ServeMux); ok {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+	return ""
+}
+
+// routeIndex is the placeholder handler registered for each route; it records the route's position
+type routeIndex int
+
+func (routeIndex) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// key builds the bucket key for r on the route matched by pattern
+func (rt *Route) key(r *http.Request, pattern string) string {
+	prefix := pattern
+	if rt.PerPath {
+		prefix = r.URL.Path
+	}
+	if rt.Extractor == nil {
+		return prefix
+	}
+	return prefix + "|" + rt.Extractor(r)
+}
+
+// RouteMiddleware returns an http.Handler middleware that applies a different
+// limit per route. Requests are matched against the route patterns with the
+// same rules as http.ServeMux, and requests matching no route use fallback.
+//
+// Buckets are keyed on the matched pattern, so "/users/1" and "/users/2" share
+// the limit of "GET /users/{id}" unless the route sets PerPath. Like
+// http.ServeMux, RouteMiddleware panics on invalid or conflicting patterns.
+func RouteMiddleware(routes []Route, fallback Route, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	config := newMiddlewareConfig(opts)
+	if config.errorHandler == nil {
+		config.errorHandler = defaultErrorHandler
+	}
+	if fallback.Pattern == "" {
+		fallback.Pattern = "default"
+	}
+
+	mux := http.NewServeMux()
+	for i, rt := range routes {
+		mux.Handle(rt.Pattern, routeIndex(i))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rt, pattern := &fallback, fallback.Pattern
+			if h, p := mux.Handler(r); p != "" {
+				if i, ok := h.(routeIndex); ok {
+					rt, pattern = &routes[i], p
+				}
+			}
+
+			if rt.Limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if rt.Priority != PriorityDefault {
+				r = r.WithContext(ContextWithPriority(r.Context(), rt.Priority))
+			}
+			if config.routeLabel {
+				r = r.WithContext(ContextWithRoute(r.Context(), pattern))
+			}
+
+			key := rt.key(r, pattern)
+			config.serve(w, r, next, rt.Limiter, func(*http.Request) string { return key })
+		})
+	}
+}
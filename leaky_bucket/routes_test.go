@@ -0,0 +1,154 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRouteMiddleware(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	user := func(r *http.Request) string { return r.Header.Get("X-User") }
+
+	mw := RouteMiddleware([]Route{
+		{Pattern: "GET /users/{id}", Limiter: New(client, 10.0), Extractor: user},
+		{Pattern: "POST /orders", Limiter: New(client, 10.0, WithBurst(2))},
+		{Pattern: "GET /files/{name}", Limiter: New(client, 10.0), PerPath: true},
+		{Pattern: "GET /healthz"},
+	}, Route{Limiter: New(client, 10.0, WithBurst(3))})
+
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(method, path, userID string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User", userID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// /users/1 and /users/2 share the endpoint bucket of the same user
+	if code := do(http.MethodGet, "/users/1", "alice"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if code := do(http.MethodGet, "/users/2", "alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected /users/2 to share the pattern bucket, got %d", code)
+	}
+	// ...but every user has a bucket of their own
+	if code := do(http.MethodGet, "/users/1", "bob"); code != http.StatusOK {
+		t.Errorf("Expected status 200 for another user, got %d", code)
+	}
+
+	// Without an extractor the route has one bucket for everyone
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := do(http.MethodPost, "/orders", "user"+string(rune('a'+i))); code != want {
+			t.Errorf("POST /orders #%d: Expected status %d, got %d", i+1, want, code)
+		}
+	}
+
+	// PerPath keys on the concrete path
+	if code := do(http.MethodGet, "/files/a", ""); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if code := do(http.MethodGet, "/files/b", ""); code != http.StatusOK {
+		t.Errorf("Expected PerPath route to key /files/b separately, got %d", code)
+	}
+
+	// Routes without a limiter are not limited
+	for i := 0; i < 5; i++ {
+		if code := do(http.MethodGet, "/healthz", ""); code != http.StatusOK {
+			t.Errorf("Expected unlimited route, got %d", code)
+		}
+	}
+
+	// Unmatched requests, including method mismatches, use the fallback route
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		path := "/other"
+		if i%2 == 1 {
+			path = "/orders" // GET does not match "POST /orders"
+		}
+		if code := do(http.MethodGet, path, ""); code != want {
+			t.Errorf("Fallback #%d: Expected status %d, got %d", i+1, want, code)
+		}
+	}
+}
+
+func TestRouteMiddleware_InvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected RouteMiddleware to panic on an invalid pattern")
+		}
+	}()
+	RouteMiddleware([]Route{{Pattern: "GET /a/{"}}, Route{})
+}
+
+// routeRecorder records the route pattern each check was made for
+type routeRecorder struct {
+	Limiter
+	routes []string
+}
+
+func (r *routeRecorder) Allow(ctx context.Context, key string) (*Result, error) {
+	r.routes = append(r.routes, RouteFromContext(ctx))
+	return r.Limiter.Allow(ctx, key)
+}
+
+func TestWithRouteLabel(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	extractor := func(r *http.Request) string { return "route_label" }
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	std := &routeRecorder{Limiter: New(client, 100.0, WithBurst(10))}
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", Middleware(std, extractor, WithRouteLabel())(ok))
+
+	gin.SetMode(gin.TestMode)
+	gn := &routeRecorder{Limiter: New(client, 100.0, WithBurst(10))}
+	router := gin.New()
+	router.Use(GinMiddleware(gn, extractor, WithRouteLabel()))
+	router.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	ec := &routeRecorder{Limiter: New(client, 100.0, WithBurst(10))}
+	e := echo.New()
+	e.Use(EchoMiddleware(ec, extractor, WithRouteLabel()))
+	e.GET("/users/:id", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	wrapped := &routeRecorder{Limiter: New(client, 100.0, WithBurst(10))}
+	outer := http.NewServeMux()
+	outer.Handle("GET /users/{id}", ok)
+	muxWrapped := Middleware(wrapped, extractor, WithRouteLabel())(outer)
+
+	rt := &routeRecorder{Limiter: New(client, 100.0, WithBurst(10))}
+	routed := RouteMiddleware([]Route{{Pattern: "GET /users/{id}", Limiter: rt}}, Route{}, WithRouteLabel())(ok)
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		limiter *routeRecorder
+		want    string
+	}{
+		{"http", mux, std, "GET /users/{id}"},
+		{"http around mux", muxWrapped, wrapped, "GET /users/{id}"},
+		{"gin", router, gn, "/users/:id"},
+		{"echo", e, ec, "/users/:id"},
+		{"routes", routed, rt, "GET /users/{id}"},
+	}
+	for _, tc := range cases {
+		tc.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		if len(tc.limiter.routes) != 1 || tc.limiter.routes[0] != tc.want {
+			t.Errorf("%s: Expected route %q, got %q", tc.name, tc.want, tc.limiter.routes)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRunnerClosed is returned by Runner.Submit after Wait has been called
+var ErrRunnerClosed = errors.New("runner is closed")
+
+// RunnerOption configures a Runner
+type RunnerOption func(*runnerConfig)
+
+type runnerConfig struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retryable  func(error) bool
+	failFast   bool
+}
+
+// WithRetry retries failed items up to attempts times in total, doubling the
+// backoff after each failure up to maxBackoff. Every attempt takes a new permit.
+func WithRetry(attempts int, backoff, maxBackoff time.Duration) RunnerOption {
+	return func(c *runnerConfig) {
+		c.attempts = attempts
+		c.backoff = backoff
+		c.maxBackoff = max(maxBackoff, backoff)
+	}
+}
+
+// WithRetryable sets which errors are retried (default is all errors except context errors)
+func WithRetryable(fn func(error) bool) RunnerOption {
+	return func(c *runnerConfig) {
+		c.retryable = fn
+	}
+}
+
+// WithFailFast cancels the remaining work after the first item fails
+func WithFailFast() RunnerOption {
+	return func(c *runnerConfig) {
+		c.failFast = true
+	}
+}
+
+// Runner processes items with a fixed number of workers that share a
+// distributed rate. Each item waits for its permits before fn is called, so
+// runners in different processes using the same key never exceed the limit
+// together.
+type Runner[T any] struct {
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	limiter Limiter
+	key     string
+	fn      func(context.Context, T) error
+	config  runnerConfig
+
+	items chan runnerItem[T]
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	errMu sync.Mutex
+	errs  []error
+}
+
+type runnerItem[T any] struct {
+	item T
+	cost int
+}
+
+// NewRunner starts concurrency workers calling fn for the submitted items.
+// Call Wait once all items are submitted.
+func NewRunner[T any](ctx context.Context, limiter Limiter, key string, concurrency int, fn func(context.Context, T) error, opts ...RunnerOption) *Runner[T] {
+	config := runnerConfig{
+		attempts: 1,
+		retryable: func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		},
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	r := &Runner[T]{
+		parent:  ctx,
+		ctx:     rctx,
+		cancel:  cancel,
+		limiter: limiter,
+		key:     key,
+		fn:      fn,
+		config:  config,
+		items:   make(chan runnerItem[T]),
+	}
+
+	r.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go r.worker()
+	}
+
+	return r
+}
+
+// Submit queues item with a cost of one permit. It blocks until a worker picks it up.
+func (r *Runner[T]) Submit(item T) error {
+	return r.SubmitN(item, 1)
+}
+
+// SubmitN queues item with a cost of n permits. Costs other than one require a
+// limiter implementing WeightedLimiter; other limiters take a single permit.
+func (r *Runner[T]) SubmitN(item T, n int) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return ErrRunnerClosed
+	}
+	select {
+	case r.items <- runnerItem[T]{item: item, cost: n}:
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
+
+// Wait stops accepting items, waits for the submitted ones to finish and
+// returns the errors of all failed items joined together. If ctx was
+// cancelled, its error is included as well.
+func (r *Runner[T]) Wait() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.items)
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	r.cancel()
+
+	r.errMu.Lock()
+	defer r.errMu.Unlock()
+	errs := r.errs
+	if err := r.parent.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// worker processes items until the queue is closed
+func (r *Runner[T]) worker() {
+	defer r.wg.Done()
+
+	for it := range r.items {
+		// Items left over after cancellation are skipped, not failed
+		if r.ctx.Err() != nil {
+			continue
+		}
+		if err := r.process(it); err != nil && r.ctx.Err() == nil {
+			r.errMu.Lock()
+			r.errs = append(r.errs, err)
+			r.errMu.Unlock()
+			if r.config.failFast {
+				r.cancel()
+			}
+		}
+	}
+}
+
+// process runs fn for one item, retrying retryable errors with backoff
+func (r *Runner[T]) process(it runnerItem[T]) error {
+	backoff := r.config.backoff
+	for attempt := 1; ; attempt++ {
+		if err := r.acquire(it.cost); err != nil {
+			return err
+		}
+
+		err := r.fn(r.ctx, it.item)
+		if err == nil {
+			return nil
+		}
+		if attempt >= r.config.attempts || !r.config.retryable(err) {
+			return err
+		}
+		if !sleepContext(r.ctx, backoff) {
+			return err
+		}
+		backoff = min(backoff*2, r.config.maxBackoff)
+	}
+}
+
+// acquire waits for the permits of one item
+func (r *Runner[T]) acquire(cost int) error {
+	if wl, ok := As[WeightedLimiter](r.limiter); ok && cost != 1 {
+		return wl.WaitN(r.ctx, r.key, cost)
+	}
+	return r.limiter.Wait(r.ctx, r.key)
+}
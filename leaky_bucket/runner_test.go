@@ -0,0 +1,158 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunner_ConcurrencyAndRate(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var running, peak, done atomic.Int32
+	fn := func(ctx context.Context, item int) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		running.Add(-1)
+		done.Add(1)
+		return nil
+	}
+
+	start := time.Now()
+	r := NewRunner(context.Background(), New(client, 20.0), "runner", 2, fn)
+	for i := 0; i < 6; i++ {
+		if err := r.Submit(i); err != nil {
+			t.Fatalf("Submit %d: Unexpected error: %v", i, err)
+		}
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if done.Load() != 6 {
+		t.Errorf("Expected 6 items processed, got %d", done.Load())
+	}
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent items, got %d", peak.Load())
+	}
+	// 6 permits at 20/s with a burst of 1 take at least 250ms
+	if elapsed < 240*time.Millisecond {
+		t.Errorf("Expected rate to hold items back, took %v", elapsed)
+	}
+
+	if err := r.Submit(7); !errors.Is(err, ErrRunnerClosed) {
+		t.Errorf("Expected ErrRunnerClosed after Wait, got %v", err)
+	}
+}
+
+func TestRunner_Retry(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+
+	var attempts atomic.Int32
+	fn := func(ctx context.Context, item string) error {
+		if item == "fatal" {
+			return errFatal
+		}
+		if attempts.Add(1) < 3 {
+			return errTransient
+		}
+		return nil
+	}
+
+	r := NewRunner(context.Background(), New(client, 1000.0, WithBurst(10)), "runner_retry", 1, fn,
+		WithRetry(3, 5*time.Millisecond, 20*time.Millisecond),
+		WithRetryable(func(err error) bool { return errors.Is(err, errTransient) }),
+	)
+	r.Submit("flaky")
+	r.Submit("fatal")
+	err := r.Wait()
+
+	if attempts.Load() != 3 {
+		t.Errorf("Expected flaky item to succeed on the 3rd attempt, got %d attempts", attempts.Load())
+	}
+	if !errors.Is(err, errFatal) || errors.Is(err, errTransient) {
+		t.Errorf("Expected only the fatal error to be reported, got %v", err)
+	}
+}
+
+func TestRunner_FailFast(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	errBoom := errors.New("boom")
+	var calls atomic.Int32
+	fn := func(ctx context.Context, item int) error {
+		calls.Add(1)
+		return errBoom
+	}
+
+	r := NewRunner(context.Background(), New(client, 1000.0, WithBurst(10)), "runner_fail_fast", 1, fn, WithFailFast())
+	for i := 0; i < 5; i++ {
+		if err := r.Submit(i); err != nil {
+			break
+		}
+	}
+	err := r.Wait()
+
+	if !errors.Is(err, errBoom) {
+		t.Errorf("Expected errBoom, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected remaining items to be skipped after the first failure, got %d calls", calls.Load())
+	}
+}
+
+func TestRunner_WeightedCost(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	limiter := &countingLimiter{WeightedLimiter: New(client, 1000.0, WithBurst(10))}
+	lb := New(client, 1000.0, WithBurst(10))
+	r := NewRunner(context.Background(), struct {
+		Limiter
+		WeightedLimiter
+	}{lb, limiter}, "runner_weighted", 1, func(ctx context.Context, item int) error { return nil })
+
+	r.SubmitN(1, 5)
+	r.Submit(2)
+	if err := r.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if limiter.calls.Load() != 1 {
+		t.Errorf("Expected only the weighted item to use WaitN, got %d calls", limiter.calls.Load())
+	}
+}
+
+func TestRunner_Cancelled(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewRunner(ctx, New(client, 0.01), "runner_cancel", 1, func(ctx context.Context, item int) error { return nil })
+
+	r.Submit(1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	r.Submit(2) // waits for a permit that never comes within the test
+
+	if err := r.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+)
+
+// shadowKeyPrefix keeps the buckets of a shadow limiter apart from those of the enforcing limiter
+const shadowKeyPrefix = "shadow:"
+
+// shadowKey is the context key marking checks whose decision is not enforced
+type shadowKey struct{}
+
+// ContextWithShadow marks the checks made with ctx as shadow checks, whose
+// decisions are not enforced. The middlewares mark the checks of WithShadow
+// and WithShadowLimiter, so that metrics, traces and logs can tell them apart.
+func ContextWithShadow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shadowKey{}, true)
+}
+
+// ShadowFromContext reports whether ctx was marked with ContextWithShadow
+func ShadowFromContext(ctx context.Context) bool {
+	shadow, _ := ctx.Value(shadowKey{}).(bool)
+	return shadow
+}
+
+// WithShadow runs the middleware in dry-run mode: the limiter is checked as
+// usual, but every request is served. Requests that would have been limited
+// trigger the WithOnLimit callback and the hooks and metrics of the limiter,
+// marked with ContextWithShadow, and no rate limit headers are sent. Shaping
+// is disabled; the allowlist, denylist and bypass rules still apply.
+func WithShadow() MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.shadow = true
+	}
+}
+
+// WithShadowLimiter also checks limiter for every request that reaches the
+// enforcing limiter, without affecting the response, and calls onLimit for
+// the requests it would have limited. This lets a new limit be compared with
+// the one in force. Shadow keys are prefixed with "shadow:" so that both
+// limiters may share a Redis instance. The shadow check adds a round trip
+// to each request.
+func WithShadowLimiter(limiter Limiter, onLimit func(r *http.Request, res *Result)) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.shadowLimiter = limiter
+		c.onShadowLimit = onLimit
+	}
+}
+
+// checkShadow asks the shadow limiter about key and reports would-be denials
+func (c *middlewareConfig) checkShadow(ctx context.Context, r *http.Request, key string) {
+	res, _ := c.shadowLimiter.Allow(ContextWithShadow(ctx), shadowKeyPrefix+key)
+	if res != nil && !res.Allowed && c.onShadowLimit != nil {
+		c.onShadowLimit(r, res)
+	}
+}
@@ -0,0 +1,102 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithShadow(t *testing.T) {
+	var limited int
+	handlers := adapterHandlers(t, WithShadow(), WithOnLimit(func(r *http.Request, res *Result) {
+		limited++
+	}))
+
+	for name, h := range handlers {
+		rec := limitedResponse(h)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected shadow mode to serve the request, got %d", name, rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "" || rec.Header().Get("Retry-After") != "" {
+			t.Errorf("%s: Expected no rate limit headers in shadow mode, got %v", name, rec.Header())
+		}
+	}
+	if limited != len(handlers) {
+		t.Errorf("Expected %d would-be denials, got %d", len(handlers), limited)
+	}
+}
+
+func TestWithShadowLimiter(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	enforcing := New(client, 10.0, WithBurst(2))
+	tighter := New(client, 10.0)
+
+	var wouldLimit int
+	mw := Middleware(enforcing, func(r *http.Request) string { return "shadowed" },
+		WithShadowLimiter(tighter, func(r *http.Request, res *Result) {
+			wouldLimit++
+		}))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes = append(codes, rec.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Expected the enforcing limit of 2 to apply, got %v", codes)
+	}
+	if wouldLimit != 2 {
+		t.Errorf("Expected the shadow limit of 1 to report 2 would-be denials, got %d", wouldLimit)
+	}
+	if n, _ := client.Exists(context.Background(), "shadow:shadowed").Result(); n != 1 {
+		t.Error("Expected the shadow limiter to use its own bucket")
+	}
+}
+
+// shadowRecorder records whether each check was marked as a shadow check
+type shadowRecorder struct {
+	Limiter
+	marks []bool
+}
+
+func (r *shadowRecorder) Allow(ctx context.Context, key string) (*Result, error) {
+	r.marks = append(r.marks, ShadowFromContext(ctx))
+	return r.Limiter.Allow(ctx, key)
+}
+
+func TestShadow_MarksChecks(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	extractor := func(r *http.Request) string { return "marked" }
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(h http.Handler) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	dryRun := &shadowRecorder{Limiter: New(client, 10.0)}
+	serve(Middleware(dryRun, extractor, WithShadow())(ok))
+	if len(dryRun.marks) != 1 || !dryRun.marks[0] {
+		t.Errorf("Expected the WithShadow check to be marked, got %v", dryRun.marks)
+	}
+
+	enforcing := &shadowRecorder{Limiter: New(client, 10.0)}
+	shadow := &shadowRecorder{Limiter: New(client, 10.0)}
+	serve(Middleware(enforcing, extractor, WithShadowLimiter(shadow, nil))(ok))
+	if len(shadow.marks) != 1 || !shadow.marks[0] {
+		t.Errorf("Expected the shadow limiter check to be marked, got %v", shadow.marks)
+	}
+	if len(enforcing.marks) != 1 || enforcing.marks[0] {
+		t.Errorf("Expected the enforcing check not to be marked, got %v", enforcing.marks)
+	}
+}
@@ -0,0 +1,189 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SlogOption configures the hooks returned by NewSlogHooks
+type SlogOption func(*slogConfig)
+
+type slogConfig struct {
+	allowLevel    slog.Level
+	denyLevel     slog.Level
+	errorLevel    slog.Level
+	fallbackLevel slog.Level
+	formatKey     func(key string) string
+	sampleLimit   int
+	samplePeriod  time.Duration
+}
+
+// WithLogLevels sets the levels of the allow, deny and backend error
+// records (default is Debug, Info and Warn). Fallbacks are logged at the
+// backend error level.
+func WithLogLevels(allow, deny, backendError slog.Level) SlogOption {
+	return func(c *slogConfig) {
+		c.allowLevel = allow
+		c.denyLevel = deny
+		c.errorLevel = backendError
+		c.fallbackLevel = backendError
+	}
+}
+
+// WithKeyFormat sets how keys appear in the logs, e.g. RedactKey or
+// HashKey (default is RedactKey)
+func WithKeyFormat(format func(key string) string) SlogOption {
+	return func(c *slogConfig) {
+		c.formatKey = format
+	}
+}
+
+// WithLogSampling logs at most limit denials per period, and as many
+// backend errors and fallbacks. The number of records left out is
+// reported in the "suppressed" attribute of the next record. A limit of 0
+// or less logs every event. The default is 100 per second.
+func WithLogSampling(limit int, period time.Duration) SlogOption {
+	return func(c *slogConfig) {
+		c.sampleLimit = limit
+		c.samplePeriod = period
+	}
+}
+
+// RedactKey replaces every key with "[redacted]"
+func RedactKey(string) string {
+	return "[redacted]"
+}
+
+// HashKey returns a key format that replaces keys with the first 16 hex
+// digits of their HMAC-SHA256 under secret, so that the records of a
+// client can be correlated without logging its IP address or user ID.
+// Keep the secret out of the logs: IP addresses and most user IDs are few
+// enough to be recovered from their hashes by anyone who knows it. Without a
+// secret a random one is used, so hashes only correlate within the process.
+func HashKey(secret []byte) func(key string) string {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return func(key string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(key))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+}
+
+// NewSlogHooks returns hooks, see WithHooks, that log the decisions of a
+// limiter to logger. Records carry the formatted key, the route attached
+// with WithRouteLabel, shadow=true for shadow checks and the details of the Result.
+func NewSlogHooks(logger *slog.Logger, opts ...SlogOption) Hooks {
+	c := &slogConfig{
+		allowLevel:    slog.LevelDebug,
+		denyLevel:     slog.LevelInfo,
+		errorLevel:    slog.LevelWarn,
+		fallbackLevel: slog.LevelWarn,
+		formatKey:     RedactKey,
+		sampleLimit:   100,
+		samplePeriod:  time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	denials := newLogSampler(c.sampleLimit, c.samplePeriod)
+	errs := newLogSampler(c.sampleLimit, c.samplePeriod)
+	fallbacks := newLogSampler(c.sampleLimit, c.samplePeriod)
+
+	// log writes a record if the level is enabled and the sampler lets it through
+	log := func(ctx context.Context, level slog.Level, sampler *logSampler, msg, key string, attrs ...slog.Attr) {
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+		var suppressed int
+		if sampler != nil {
+			var ok bool
+			if ok, suppressed = sampler.take(); !ok {
+				return
+			}
+		}
+		attrs = append(attrs, slog.String("key", c.formatKey(key)))
+		if route := RouteFromContext(ctx); route != "" {
+			attrs = append(attrs, slog.String("route", route))
+		}
+		if ShadowFromContext(ctx) {
+			attrs = append(attrs, slog.Bool("shadow", true))
+		}
+		if suppressed > 0 {
+			attrs = append(attrs, slog.Int("suppressed", suppressed))
+		}
+		logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+
+	return Hooks{
+		OnAllow: func(ctx context.Context, key string, res *Result) {
+			log(ctx, c.allowLevel, nil, "rate limit allowed", key,
+				slog.Int("remaining", res.Remaining),
+				slog.Duration("wait", res.WaitTime),
+				slog.String("source", res.Source.String()))
+		},
+		OnDeny: func(ctx context.Context, key string, res *Result) {
+			log(ctx, c.denyLevel, denials, "rate limit exceeded", key,
+				slog.Duration("retry_after", res.WaitTime),
+				slog.Float64("limit", res.Limit),
+				slog.String("source", res.Source.String()))
+		},
+		OnBackendError: func(ctx context.Context, key string, err error) {
+			log(ctx, c.errorLevel, errs, "rate limit backend error", key,
+				slog.String("error", err.Error()),
+				slog.String("class", ErrorClass(err)))
+		},
+		OnFallback: func(ctx context.Context, key string, res *Result) {
+			log(ctx, c.fallbackLevel, fallbacks, "rate limit fallback", key,
+				slog.Bool("allowed", res.Allowed),
+				slog.String("source", res.Source.String()))
+		},
+	}
+}
+
+// logSampler lets through at most limit records per period
+type logSampler struct {
+	limit  int
+	period time.Duration
+
+	mu         sync.Mutex
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+func newLogSampler(limit int, period time.Duration) *logSampler {
+	if limit <= 0 {
+		return nil
+	}
+	return &logSampler{limit: limit, period: period}
+}
+
+// take reports whether a record may be written, and how many were left out since the last one
+func (s *logSampler) take() (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.start) >= s.period {
+		s.start = now
+		s.count = 0
+	}
+	if s.count >= s.limit {
+		s.suppressed++
+		return false, 0
+	}
+	s.count++
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return true, suppressed
+}
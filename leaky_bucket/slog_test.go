@@ -0,0 +1,169 @@
+package leaky_bucket_redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecords decodes the JSON records written to buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestNewSlogHooks(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	lb := New(client, 1.0, WithHooks(NewSlogHooks(logger)))
+	ctx := ContextWithRoute(context.Background(), "GET /login")
+
+	lb.Allow(ctx, "203.0.113.7")
+	lb.Allow(ctx, "203.0.113.7")
+
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected only the denial to be logged at Info, got %d records", len(records))
+	}
+	rec := records[0]
+	if rec["msg"] != "rate limit exceeded" || rec["level"] != "INFO" {
+		t.Errorf("Expected an INFO denial record, got %v", rec)
+	}
+	if rec["key"] != "[redacted]" || strings.Contains(buf.String(), "203.0.113.7") {
+		t.Errorf("Expected the key to be redacted, got %v", rec["key"])
+	}
+	if rec["route"] != "GET /login" {
+		t.Errorf("Expected route GET /login, got %v", rec["route"])
+	}
+	if _, ok := rec["shadow"]; ok {
+		t.Errorf("Expected no shadow attribute on an enforced check, got %v", rec["shadow"])
+	}
+}
+
+func TestNewSlogHooks_Shadow(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	lb := New(client, 1.0, WithHooks(NewSlogHooks(logger)))
+	ctx := ContextWithShadow(context.Background())
+
+	lb.Allow(ctx, "user:1")
+	lb.Allow(ctx, "user:1")
+
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	if records[0]["shadow"] != true {
+		t.Errorf("Expected shadow=true on a shadow check, got %v", records[0]["shadow"])
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	secret := []byte("log-secret")
+	if HashKey(secret)("user:1") != HashKey(secret)("user:1") {
+		t.Error("Expected the same secret to give the same hash")
+	}
+	if HashKey(secret)("user:1") == HashKey(secret)("user:2") {
+		t.Error("Expected different keys to give different hashes")
+	}
+
+	// Without a secret, the hash cannot be recomputed elsewhere
+	if HashKey(nil)("203.0.113.7") == HashKey(nil)("203.0.113.7") {
+		t.Error("Expected HashKey without a secret to use a random one")
+	}
+}
+
+func TestNewSlogHooks_Levels(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	lb := New(client, 1.0, WithHooks(NewSlogHooks(logger,
+		WithLogLevels(slog.LevelInfo, slog.LevelWarn, slog.LevelError),
+		WithKeyFormat(RedactKey),
+	)))
+
+	lb.Allow(context.Background(), "user:1")
+	lb.Allow(context.Background(), "user:1")
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0]["level"] != "INFO" || records[1]["level"] != "WARN" {
+		t.Errorf("Expected INFO and WARN records, got %v and %v", records[0]["level"], records[1]["level"])
+	}
+	if records[0]["key"] != "[redacted]" {
+		t.Errorf("Expected the key to be redacted, got %v", records[0]["key"])
+	}
+}
+
+func TestNewSlogHooks_Sampling(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	lb := New(client, 1.0/3600.0, WithHooks(NewSlogHooks(logger, WithLogSampling(2, 50*time.Millisecond))))
+	ctx := context.Background()
+
+	lb.Allow(ctx, "flood")
+	for i := 0; i < 5; i++ {
+		lb.Allow(ctx, "flood")
+	}
+	if n := len(logRecords(t, &buf)); n != 2 {
+		t.Fatalf("Expected 2 sampled denials, got %d", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	lb.Allow(ctx, "flood")
+
+	records := logRecords(t, &buf)
+	if last := records[len(records)-1]; last["suppressed"] != float64(3) {
+		t.Errorf("Expected 3 suppressed denials to be reported, got %v", last["suppressed"])
+	}
+}
+
+func TestNewSlogHooks_BackendError(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	lb := New(client, 1.0, WithHooks(NewSlogHooks(logger)))
+
+	lb.Allow(context.Background(), "down")
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("Expected a backend error and a fallback record, got %d", len(records))
+	}
+	if records[0]["msg"] != "rate limit backend error" || records[0]["class"] != "backend_unavailable" || records[0]["level"] != "WARN" {
+		t.Errorf("Expected a WARN backend error record, got %v", records[0])
+	}
+	if records[1]["msg"] != "rate limit fallback" || records[1]["source"] != "failure-policy" {
+		t.Errorf("Expected a fallback record, got %v", records[1])
+	}
+}
@@ -0,0 +1,265 @@
+// Package telemetry instruments rate limiters with OpenTelemetry.
+//
+// Telemetry wraps any leaky_bucket.Limiter in a decorator that creates a span
+// around every check and wait, and records decision counts and latencies as
+// OpenTelemetry metrics. Spans are children of the span in the context passed
+// to the limiter, so the middlewares attach them to the request's trace.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	leaky_bucket "github.com/alibazlamit/leaky_bucket_redis/v2/leaky_bucket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scope is the instrumentation scope of the tracer and meter
+const scope = "github.com/alibazlamit/leaky_bucket_redis/v2/leaky_bucket/telemetry"
+
+// Attribute keys set on spans and metrics
+const (
+	LimiterKey  = attribute.Key("ratelimit.limiter")   // Name given to Wrap
+	RouteKey    = attribute.Key("ratelimit.route")     // Route pattern, see leaky_bucket.WithRouteLabel
+	ShadowKey   = attribute.Key("ratelimit.shadow")    // Whether the decision is not enforced, see leaky_bucket.ContextWithShadow
+	DecisionKey = attribute.Key("ratelimit.decision")  // "allowed" or "denied"
+	BackendKey  = attribute.Key("ratelimit.backend")   // Source of the decision, e.g. "redis" or "local"
+	WaitKey     = attribute.Key("ratelimit.wait_time") // Wait time in seconds
+	OutcomeKey  = attribute.Key("ratelimit.outcome")   // Outcome of a wait: "acquired", "cancelled" or "error"
+	ErrorKey    = attribute.Key("ratelimit.error")     // Class of the error, see leaky_bucket.ErrorClass
+)
+
+// errNotWeighted is returned by AllowN and WaitN for several permits when the
+// wrapped limiter is not a leaky_bucket.WeightedLimiter
+var errNotWeighted = errors.New("telemetry: wrapped limiter does not implement leaky_bucket.WeightedLimiter")
+
+// Option configures the Telemetry
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// WithTracerProvider sets the tracer provider (default is the global one)
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the meter provider (default is the global one)
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// Telemetry holds the tracer and instruments shared by all the limiters it wraps
+type Telemetry struct {
+	tracer    trace.Tracer
+	decisions metric.Int64Counter
+	errors    metric.Int64Counter
+	checks    metric.Float64Histogram
+	waits     metric.Float64Histogram
+}
+
+// New creates the tracer and the metric instruments
+func New(opts ...Option) (*Telemetry, error) {
+	c := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	meter := c.meterProvider.Meter(scope)
+	t := &Telemetry{tracer: c.tracerProvider.Tracer(scope)}
+
+	var err error
+	if t.decisions, err = meter.Int64Counter("ratelimit.decisions",
+		metric.WithDescription("Rate limit decisions by limiter, route, decision and backend."),
+		metric.WithUnit("{decision}")); err != nil {
+		return nil, err
+	}
+	if t.errors, err = meter.Int64Counter("ratelimit.errors",
+		metric.WithDescription("Errors returned by rate limit checks and waits, by limiter and class."),
+		metric.WithUnit("{error}")); err != nil {
+		return nil, err
+	}
+	if t.checks, err = meter.Float64Histogram("ratelimit.check.duration",
+		metric.WithDescription("Latency of rate limit checks, including the Redis script."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if t.waits, err = meter.Float64Histogram("ratelimit.wait.duration",
+		metric.WithDescription("Time spent waiting for a permit."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Wrap returns limiter instrumented under name, e.g. "api" or "login"
+func (t *Telemetry) Wrap(limiter leaky_bucket.Limiter, name string) *Limiter {
+	return &Limiter{next: limiter, name: name, telemetry: t}
+}
+
+// Limiter is a leaky_bucket.Limiter that traces and measures every call.
+// It also implements leaky_bucket.Reserver and leaky_bucket.WeightedLimiter
+// so that shaping and bandwidth limiting are traced too; the adapters reach
+// other optional interfaces, such as leaky_bucket.Pauser, through Unwrap.
+type Limiter struct {
+	next      leaky_bucket.Limiter
+	name      string
+	telemetry *Telemetry
+}
+
+// Unwrap returns the wrapped limiter
+func (l *Limiter) Unwrap() leaky_bucket.Limiter {
+	return l.next
+}
+
+// Allow checks the wrapped limiter within a span
+func (l *Limiter) Allow(ctx context.Context, key string) (*leaky_bucket.Result, error) {
+	return l.check(ctx, "ratelimit.Allow", func(ctx context.Context) (*leaky_bucket.Result, error) {
+		return l.next.Allow(ctx, key)
+	})
+}
+
+// Reserve reserves through the wrapped limiter if it is a leaky_bucket.Reserver,
+// or else checks it with Allow, within a span
+func (l *Limiter) Reserve(ctx context.Context, key string, maxWait time.Duration) (*leaky_bucket.Result, error) {
+	rsv, ok := leaky_bucket.As[leaky_bucket.Reserver](l.next)
+	if !ok {
+		return l.Allow(ctx, key)
+	}
+	return l.check(ctx, "ratelimit.Reserve", func(ctx context.Context) (*leaky_bucket.Result, error) {
+		return rsv.Reserve(ctx, key, maxWait)
+	})
+}
+
+// AllowN checks a request costing n permits through the wrapped limiter within
+// a span. Limiters that are not a leaky_bucket.WeightedLimiter only support n == 1.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (*leaky_bucket.Result, error) {
+	wl, ok := leaky_bucket.As[leaky_bucket.WeightedLimiter](l.next)
+	if !ok {
+		if n != 1 {
+			return nil, errNotWeighted
+		}
+		return l.Allow(ctx, key)
+	}
+	return l.check(ctx, "ratelimit.Allow", func(ctx context.Context) (*leaky_bucket.Result, error) {
+		return wl.AllowN(ctx, key, n)
+	})
+}
+
+// WaitN waits for n permits through the wrapped limiter within a span.
+// Limiters that are not a leaky_bucket.WeightedLimiter only support n == 1.
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	wl, ok := leaky_bucket.As[leaky_bucket.WeightedLimiter](l.next)
+	if !ok {
+		if n != 1 {
+			return errNotWeighted
+		}
+		return l.Wait(ctx, key)
+	}
+	return l.wait(ctx, "ratelimit.Wait", func(ctx context.Context) error {
+		return wl.WaitN(ctx, key, n)
+	})
+}
+
+// Wait waits through the wrapped limiter within a span
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.wait(ctx, "ratelimit.Wait", func(ctx context.Context) error {
+		return l.next.Wait(ctx, key)
+	})
+}
+
+// WaitTimeout waits through the wrapped limiter within a span
+func (l *Limiter) WaitTimeout(ctx context.Context, key string, timeout time.Duration) error {
+	return l.wait(ctx, "ratelimit.Wait", func(ctx context.Context) error {
+		return l.next.WaitTimeout(ctx, key, timeout)
+	})
+}
+
+// labels returns the attributes shared by the spans and metrics of a call
+func (l *Limiter) labels(ctx context.Context) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		LimiterKey.String(l.name),
+		RouteKey.String(leaky_bucket.RouteFromContext(ctx)),
+		ShadowKey.Bool(leaky_bucket.ShadowFromContext(ctx)),
+	}
+}
+
+// check runs fn in a span named name and records its decision
+func (l *Limiter) check(ctx context.Context, name string, fn func(context.Context) (*leaky_bucket.Result, error)) (*leaky_bucket.Result, error) {
+	t := l.telemetry
+	labels := l.labels(ctx)
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(labels...))
+	defer span.End()
+
+	start := time.Now()
+	res, err := fn(ctx)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		l.fail(ctx, span, labels, err)
+	}
+	if res == nil {
+		return res, err
+	}
+
+	decision := "denied"
+	if res.Allowed {
+		decision = "allowed"
+	}
+	outcome := append(labels, DecisionKey.String(decision), BackendKey.String(res.Source.String()))
+	span.SetAttributes(outcome[len(labels):]...)
+	span.SetAttributes(WaitKey.Float64(res.WaitTime.Seconds()))
+
+	t.decisions.Add(ctx, 1, metric.WithAttributes(outcome...))
+	t.checks.Record(ctx, elapsed.Seconds(), metric.WithAttributes(LimiterKey.String(l.name), BackendKey.String(res.Source.String())))
+	return res, err
+}
+
+// wait runs fn in a span named name and records the time spent
+func (l *Limiter) wait(ctx context.Context, name string, fn func(context.Context) error) error {
+	t := l.telemetry
+	labels := l.labels(ctx)
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(labels...))
+	defer span.End()
+
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+
+	outcome := "acquired"
+	switch class := leaky_bucket.ErrorClass(err); {
+	case err == nil:
+	case class == "cancelled":
+		outcome = "cancelled"
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		outcome = "error"
+		l.fail(ctx, span, labels, err)
+	}
+
+	span.SetAttributes(OutcomeKey.String(outcome), WaitKey.Float64(elapsed.Seconds()))
+	t.waits.Record(ctx, elapsed.Seconds(), metric.WithAttributes(append(labels, OutcomeKey.String(outcome))...))
+	return err
+}
+
+// fail records err on span and counts it
+func (l *Limiter) fail(ctx context.Context, span trace.Span, labels []attribute.KeyValue, err error) {
+	class := ErrorKey.String(leaky_bucket.ErrorClass(err))
+	span.RecordError(err, trace.WithAttributes(class))
+	span.SetStatus(codes.Error, err.Error())
+	l.telemetry.errors.Add(ctx, 1, metric.WithAttributes(append(labels, class)...))
+}
@@ -0,0 +1,248 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	leaky_bucket "github.com/alibazlamit/leaky_bucket_redis/v2/leaky_bucket"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func createTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+// newTelemetry returns a Telemetry exporting to an in-memory span recorder and metric reader
+func newTelemetry(t *testing.T) (*Telemetry, *sdktrace.TracerProvider, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	tel, err := New(WithTracerProvider(tp), WithMeterProvider(mp))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return tel, tp, spans, reader
+}
+
+// attr returns the value of key on span, or an invalid value
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// counterSum returns the sum of the data points of the named counter matching filter
+func counterSum(t *testing.T, reader *sdkmetric.ManualReader, name string, filter attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var sum int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				if v, ok := dp.Attributes.Value(filter.Key); ok && v == filter.Value {
+					sum += dp.Value
+				}
+			}
+		}
+	}
+	return sum
+}
+
+func TestLimiter_AllowSpans(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	tel, _, spans, reader := newTelemetry(t)
+	limiter := tel.Wrap(leaky_bucket.New(client, 1.0), "api")
+	ctx := context.Background()
+
+	limiter.Allow(ctx, "user:1")
+	limiter.Allow(ctx, "user:1")
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(ended))
+	}
+	for i, want := range []string{"allowed", "denied"} {
+		span := ended[i]
+		if span.Name() != "ratelimit.Allow" {
+			t.Errorf("Expected span ratelimit.Allow, got %s", span.Name())
+		}
+		if v := attr(span, LimiterKey).AsString(); v != "api" {
+			t.Errorf("Expected limiter api, got %q", v)
+		}
+		if v := attr(span, DecisionKey).AsString(); v != want {
+			t.Errorf("Expected decision %s, got %q", want, v)
+		}
+		if v := attr(span, BackendKey).AsString(); v != "redis" {
+			t.Errorf("Expected backend redis, got %q", v)
+		}
+	}
+	if wait := attr(ended[1], WaitKey).AsFloat64(); wait <= 0 {
+		t.Errorf("Expected the denied span to carry the wait time, got %v", wait)
+	}
+
+	if n := counterSum(t, reader, "ratelimit.decisions", DecisionKey.String("denied")); n != 1 {
+		t.Errorf("Expected 1 denied decision, got %d", n)
+	}
+}
+
+func TestLimiter_Shadow(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	tel, _, spans, reader := newTelemetry(t)
+	limiter := tel.Wrap(leaky_bucket.New(client, 1.0), "api")
+
+	limiter.Allow(context.Background(), "user:1")
+	limiter.Allow(leaky_bucket.ContextWithShadow(context.Background()), "user:1")
+
+	ended := spans.Ended()
+	if attr(ended[0], ShadowKey).AsBool() || !attr(ended[1], ShadowKey).AsBool() {
+		t.Errorf("Expected only the second span to be marked as shadow")
+	}
+	if n := counterSum(t, reader, "ratelimit.decisions", ShadowKey.Bool(true)); n != 1 {
+		t.Errorf("Expected 1 shadow decision, got %d", n)
+	}
+}
+
+func TestLimiter_BackendError(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	tel, _, spans, reader := newTelemetry(t)
+	limiter := tel.Wrap(leaky_bucket.New(client, 1.0), "api")
+
+	limiter.Allow(context.Background(), "user:1")
+
+	span := spans.Ended()[0]
+	if span.Status().Code != codes.Error {
+		t.Errorf("Expected error status, got %v", span.Status())
+	}
+	if v := attr(span, BackendKey).AsString(); v != "failure-policy" {
+		t.Errorf("Expected backend failure-policy, got %q", v)
+	}
+	if n := counterSum(t, reader, "ratelimit.errors", ErrorKey.String("backend_unavailable")); n != 1 {
+		t.Errorf("Expected 1 backend error, got %d", n)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	tel, _, spans, _ := newTelemetry(t)
+	limiter := tel.Wrap(leaky_bucket.New(client, 20.0), "worker")
+
+	if err := limiter.Wait(context.Background(), "jobs"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	span := spans.Ended()[0]
+	if span.Name() != "ratelimit.Wait" {
+		t.Errorf("Expected span ratelimit.Wait, got %s", span.Name())
+	}
+	if v := attr(span, OutcomeKey).AsString(); v != "acquired" {
+		t.Errorf("Expected outcome acquired, got %q", v)
+	}
+}
+
+func TestLimiter_OptionalInterfaces(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	tel, _, spans, _ := newTelemetry(t)
+	lb := leaky_bucket.New(client, 10.0, leaky_bucket.WithBurst(5))
+	limiter := tel.Wrap(lb, "api")
+	ctx := context.Background()
+
+	// Weighted checks are traced, e.g. by leaky_bucket.NewReader
+	var _ leaky_bucket.WeightedLimiter = limiter
+	if res, err := limiter.AllowN(ctx, "bytes", 3); err != nil || res.Remaining != 2 {
+		t.Fatalf("Expected 3 of 5 permits to be taken, got %+v, %v", res, err)
+	}
+	if n := len(spans.Ended()); n != 1 {
+		t.Errorf("Expected the weighted check to be traced, got %d spans", n)
+	}
+
+	// The transport pauses the wrapped bucket on upstream 429s
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodGet, srv.URL, nil)
+	req.RequestURI = ""
+	resp, err := leaky_bucket.NewTransport(limiter, leaky_bucket.ExtractHost).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if res, _ := lb.Allow(ctx, leaky_bucket.ExtractHost(req)); res.Allowed {
+		t.Error("Expected the transport to pause the bucket behind the wrapper")
+	}
+}
+
+func TestAdapters_Propagation(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	tel, tp, spans, _ := newTelemetry(t)
+	limiter := tel.Wrap(leaky_bucket.New(client, 100.0, leaky_bucket.WithBurst(10)), "api")
+	extractor := func(r *http.Request) string { return "propagation" }
+
+	std := leaky_bucket.Middleware(limiter, extractor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(leaky_bucket.GinMiddleware(limiter, extractor))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	e := echo.New()
+	e.Use(leaky_bucket.EchoMiddleware(limiter, extractor))
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	for name, h := range map[string]http.Handler{"http": std, "gin": router, "echo": e} {
+		// The request span of an instrumented server
+		ctx, parent := tp.Tracer("server").Start(context.Background(), "GET /")
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		parent.End()
+
+		ended := spans.Ended()
+		child := ended[len(ended)-2]
+		if child.Name() != "ratelimit.Allow" {
+			t.Fatalf("%s: Expected span ratelimit.Allow, got %s", name, child.Name())
+		}
+		if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s: Expected the limiter span to be a child of the request span", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// Tick returns a channel that receives the time each time a permit for key is
+// granted. The next permit is only reserved once the previous tick has been
+// received, so a slow consumer does not build up a backlog in Redis. The
+// channel is closed when ctx is done or the limiter returns an error.
+func (lb *LeakyBucketRedis) Tick(ctx context.Context, key string) <-chan time.Time {
+	ch := make(chan time.Time)
+	go func() {
+		defer close(ch)
+		for t := range permits(ctx, lb, key) {
+			select {
+			case ch <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Permits returns an iterator that yields the time each time a permit for key
+// is granted, until ctx is done or the limiter returns an error:
+//
+//	for range limiter.Permits(ctx, "export") {
+//		sendNextBatch()
+//	}
+func (lb *LeakyBucketRedis) Permits(ctx context.Context, key string) iter.Seq[time.Time] {
+	return permits(ctx, lb, key)
+}
+
+// permits reserves one permit at a time and sleeps until it is due, so Redis
+// is called once per permit instead of being polled
+func permits(ctx context.Context, limiter *LeakyBucketRedis, key string) iter.Seq[time.Time] {
+	return func(yield func(time.Time) bool) {
+		var timer *time.Timer
+		for ctx.Err() == nil {
+			// Never reserve a permit that is due after ctx expires. The failure
+			// policy would let every permit through at once, so errors end the loop.
+			res, err := limiter.Reserve(ctx, key, maxWaitFor(ctx))
+			if err != nil || !res.Allowed {
+				return
+			}
+
+			if res.WaitTime > 0 {
+				if timer == nil {
+					timer = time.NewTimer(res.WaitTime)
+					defer timer.Stop()
+				} else {
+					timer.Reset(res.WaitTime)
+				}
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					releaseSlot(ctx, limiter, key, res)
+					return
+				}
+			}
+
+			if !yield(time.Now()) {
+				return
+			}
+		}
+	}
+}
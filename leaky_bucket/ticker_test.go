@@ -0,0 +1,90 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucketRedis_Tick(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 20.0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ticks := lb.Tick(ctx, "ticker")
+	start := time.Now()
+	var last time.Time
+	for i := 0; i < 4; i++ {
+		last = <-ticks
+	}
+
+	// One permit immediately, then one every 50ms
+	if elapsed := last.Sub(start); elapsed < 140*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("Expected 4 ticks in about 150ms, got %v", elapsed)
+	}
+
+	// A tick already due may still be delivered, then the channel is closed
+	cancel()
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-ticks:
+		case <-timeout:
+			t.Fatal("Channel was not closed after cancel")
+		}
+	}
+}
+
+func TestLeakyBucketRedis_Permits(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	lb := New(client, 20.0)
+	ctx := context.Background()
+
+	start := time.Now()
+	n := 0
+	for range lb.Permits(ctx, "permits") {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 3 permits to take about 100ms, took %v", elapsed)
+	}
+
+	// Breaking out of the loop must not leave a permit reserved
+	res, _ := lb.Reserve(ctx, "permits", time.Second)
+	if res.WaitTime > 60*time.Millisecond {
+		t.Errorf("Expected next permit within one interval, got %v", res.WaitTime)
+	}
+
+	// Permits that are due after the deadline are never reserved
+	dctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	for range lb.Permits(dctx, "permits") {
+		t.Error("Expected no permit before the deadline")
+	}
+}
+
+func TestLeakyBucketRedis_PermitsBackendError(t *testing.T) {
+	client := createTestClient(t)
+	client.Close() // Force fail
+
+	lb := New(client, 20.0, WithFailurePolicy(FailOpen))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	n := 0
+	for range lb.Permits(ctx, "permits_error") {
+		if n++; n > 1 {
+			break
+		}
+	}
+	if n != 0 {
+		t.Errorf("Expected no permits while Redis is down, got %d", n)
+	}
+}
@@ -0,0 +1,249 @@
+package leaky_bucket_redis
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExtractHost returns the host of an outgoing request as the key
+func ExtractHost(r *http.Request) string {
+	return r.URL.Host
+}
+
+// ExtractHostPath returns the host and path of an outgoing request as the key
+func ExtractHostPath(r *http.Request) string {
+	return r.URL.Host + r.URL.Path
+}
+
+// Transport is an http.RoundTripper that rate limits outgoing requests,
+// for example to stay within a third-party API's per-account limits.
+//
+// When the limiter implements Pauser, Transport also honors the upstream's
+// back-off signals: Retry-After on 429 and 503 responses, and exhausted
+// RateLimit / X-RateLimit headers pause the shared bucket for every instance.
+// When it implements FeedbackReceiver, such as Adaptive, the outcome of every
+// request is reported so the limiter can learn the upstream's limit.
+type Transport struct {
+	base      http.RoundTripper
+	limiter   Limiter
+	extractor KeyExtractor
+	maxWait   time.Duration
+	upstream  bool
+	latency   time.Duration
+}
+
+// TransportOption configures a Transport
+type TransportOption func(*Transport)
+
+// WithBaseTransport sets the RoundTripper that performs the requests (default is http.DefaultTransport)
+func WithBaseTransport(base http.RoundTripper) TransportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+// WithMaxWait makes Transport wait up to d for the limiter instead of failing
+// immediately. With the default of 0, limited requests fail with ErrRateLimited.
+func WithMaxWait(d time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.maxWait = d
+	}
+}
+
+// WithUpstreamPause enables or disables pausing the bucket on upstream
+// back-off signals (default is enabled)
+func WithUpstreamPause(enabled bool) TransportOption {
+	return func(t *Transport) {
+		t.upstream = enabled
+	}
+}
+
+// WithLatencyTarget sets the latency above which a response is reported as
+// OutcomeSlow to limiters implementing FeedbackReceiver (default is no target)
+func WithLatencyTarget(d time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.latency = d
+	}
+}
+
+// NewTransport creates a Transport that limits requests by the key extractor returns
+func NewTransport(limiter Limiter, extractor KeyExtractor, opts ...TransportOption) *Transport {
+	t := &Transport{
+		base:      http.DefaultTransport,
+		limiter:   limiter,
+		extractor: extractor,
+		upstream:  true,
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.extractor(req)
+
+	if err := t.acquire(req, key); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if fb, ok := As[FeedbackReceiver](t.limiter); ok {
+		fb.Feedback(req.Context(), key, t.outcome(resp, time.Since(start)))
+	}
+
+	if pauser, ok := As[Pauser](t.limiter); ok && t.upstream {
+		if d := upstreamPause(resp, time.Now()); d > 0 {
+			pauser.Pause(req.Context(), key, d)
+		}
+	}
+
+	return resp, nil
+}
+
+// acquire waits for, or checks, a permit for key. Limiter errors fail open,
+// except for configuration errors, which are returned.
+func (t *Transport) acquire(req *http.Request, key string) error {
+	ctx := req.Context()
+
+	if t.maxWait <= 0 {
+		res, err := t.limiter.Allow(ctx, key)
+		if res == nil {
+			return failOpen(err)
+		}
+		if res.Allowed {
+			return nil
+		}
+		return fmt.Errorf("%w: retry after %v", ErrRateLimited, res.WaitTime)
+	}
+
+	rsv, ok := As[Reserver](t.limiter)
+	if !ok {
+		return t.limiter.WaitTimeout(ctx, key, t.maxWait)
+	}
+
+	res, err := rsv.Reserve(ctx, key, t.maxWait)
+	if res == nil {
+		return failOpen(err)
+	}
+	if !res.Allowed {
+		return fmt.Errorf("%w: retry after %v", ErrRateLimited, res.WaitTime)
+	}
+	if res.WaitTime > 0 && !sleepContext(ctx, res.WaitTime) {
+		releaseSlot(ctx, t.limiter, key, res)
+		return ctx.Err()
+	}
+	return nil
+}
+
+// outcome classifies a response for FeedbackReceiver limiters
+func (t *Transport) outcome(resp *http.Response, latency time.Duration) Outcome {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		return OutcomeThrottled
+	case t.latency > 0 && latency > t.latency:
+		return OutcomeSlow
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// upstreamPause returns how long the upstream asked us to back off, or 0
+func upstreamPause(resp *http.Response, now time.Time) time.Duration {
+	h := resp.Header
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if d := parseRetryAfter(h.Get("Retry-After"), now); d > 0 {
+			return d
+		}
+	}
+
+	// IETF draft: RateLimit: limit=100, remaining=0, reset=30
+	// or the newer form: RateLimit: "default";r=0;t=30
+	if v := h.Get("RateLimit"); v != "" {
+		params := parseRateLimitParams(v)
+		if remaining, ok := firstParam(params, "remaining", "r"); ok && remaining == "0" {
+			if reset, ok := firstParam(params, "reset", "t"); ok {
+				return parseResetSeconds(reset, now)
+			}
+		}
+	}
+
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		if h.Get(prefix+"Remaining") == "0" {
+			return parseResetSeconds(h.Get(prefix+"Reset"), now)
+		}
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After value given in seconds or as an HTTP date
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// parseResetSeconds parses a reset value in seconds. Values that look like
+// a Unix timestamp, as sent by some APIs in X-RateLimit-Reset, are converted
+// to the time left until then.
+func parseResetSeconds(v string, now time.Time) time.Duration {
+	secs, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	if secs > 1e9 {
+		return time.Unix(int64(secs), 0).Sub(now)
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// parseRateLimitParams splits the parameters of a RateLimit header into a map
+func parseRateLimitParams(v string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' }) {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			params[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
+
+func firstParam(params map[string]string, names ...string) (string, bool) {
+	for _, n := range names {
+		if v, ok := params[n]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// failOpen drops err unless it is a configuration error, see failsOpen
+func failOpen(err error) error {
+	if failsOpen(err) {
+		return nil
+	}
+	return err
+}
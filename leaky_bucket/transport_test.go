@@ -0,0 +1,149 @@
+package leaky_bucket_redis
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamPause(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		want   time.Duration
+	}{
+		{"retry-after seconds", 429, map[string]string{"Retry-After": "2"}, 2 * time.Second},
+		{"retry-after date", 503, map[string]string{"Retry-After": now.Add(5 * time.Second).Format(http.TimeFormat)}, 5 * time.Second},
+		{"retry-after ignored on 200", 200, map[string]string{"Retry-After": "2"}, 0},
+		{"ratelimit draft", 200, map[string]string{"RateLimit": "limit=100, remaining=0, reset=30"}, 30 * time.Second},
+		{"ratelimit structured", 200, map[string]string{"RateLimit": `"default";r=0;t=7`}, 7 * time.Second},
+		{"ratelimit not exhausted", 200, map[string]string{"RateLimit": "limit=100, remaining=5, reset=30"}, 0},
+		{"ratelimit fields", 200, map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "3"}, 3 * time.Second},
+		{"x-ratelimit epoch", 200, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1767268810"}, 10 * time.Second},
+		{"no headers", 200, nil, 0},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		for k, v := range tt.header {
+			resp.Header.Set(k, v)
+		}
+		if got := upstreamPause(resp, now); got != tt.want {
+			t.Errorf("%s: Expected pause %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTransport_Allow(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	httpClient := &http.Client{Transport: NewTransport(New(client, 10.0), ExtractHost)}
+
+	resp, err := httpClient.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected first request to succeed, got %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = httpClient.Get(srv.URL)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Expected limited request not to reach the server, got %d hits", n)
+	}
+}
+
+func TestTransport_MaxWait(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	httpClient := &http.Client{Transport: NewTransport(New(client, 10.0), ExtractHost, WithMaxWait(time.Second))}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := httpClient.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Request %d: Expected to wait and succeed, got %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected requests to be spaced out, took %v", elapsed)
+	}
+}
+
+func TestTransport_MaxWaitCancelled(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	lb := New(client, 1.0)
+	httpClient := &http.Client{Transport: NewTransport(lb, ExtractHost, WithMaxWait(time.Second))}
+
+	resp, err := httpClient.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := httpClient.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline error while waiting, got %v", err)
+	}
+
+	// The slot of the cancelled request was handed back
+	res, _ := lb.Reserve(context.Background(), ExtractHost(req), time.Second)
+	if !res.Allowed {
+		t.Errorf("Expected the released slot to be available, got wait %v", res.WaitTime)
+	}
+}
+
+func TestTransport_UpstreamRetryAfter(t *testing.T) {
+	client := createTestClient(t)
+	defer client.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	lb := New(client, 100.0, WithBurst(10))
+
+	// Two pods sharing the bucket
+	podA := &http.Client{Transport: NewTransport(lb, ExtractHost)}
+	podB := &http.Client{Transport: NewTransport(New(client, 100.0, WithBurst(10)), ExtractHost)}
+
+	resp, err := podA.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = podB.Get(srv.URL)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected upstream Retry-After to pause the shared bucket, got %v", err)
+	}
+}
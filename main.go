@@ -64,7 +64,9 @@ func basicDemo(client redis.UniversalClient) {
 		res, err := limiter.Allow(ctx, key)
 		if err != nil {
 			log.Printf("Error: %v\n", err)
-			continue
+			if res == nil {
+				continue
+			}
 		}
 
 		if res.Allowed {
@@ -100,8 +102,8 @@ func concurrentDemo(client redis.UniversalClient) {
 			defer wg.Done()
 
 			ctx := context.Background()
-			res, err := limiter.Allow(ctx, key)
-			if err != nil {
+			res, _ := limiter.Allow(ctx, key)
+			if res == nil {
 				return
 			}
 